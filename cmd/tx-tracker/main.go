@@ -2,26 +2,40 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"log"
 	"os"
+	"tx-tracker/pkg/bitcoind"
+	"tx-tracker/pkg/discord"
+	"tx-tracker/pkg/electrum"
+	"tx-tracker/pkg/email"
+	"tx-tracker/pkg/matrix"
 	"tx-tracker/pkg/mempool"
 	"tx-tracker/pkg/models"
 	slackUtils "tx-tracker/pkg/slack"
+	"tx-tracker/pkg/storage"
+	"tx-tracker/pkg/telegram"
 	"tx-tracker/pkg/utils"
+	"tx-tracker/pkg/webhook"
 
+	"github.com/bwmarrin/discordgo"
 	"github.com/joho/godotenv"
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/socketmode"
 )
 
-func HandleSignals[T comparable](cancel func(), fileName string, toSave *utils.Set[models.WatchTx]) {
+// HandleSignals snapshots the store on SIGINT or SIGTERM, a nil snapshotter skips the snapshot for storage that
+// already has every change
+func HandleSignals(cancel func(), snapshotter *utils.Snapshotter, toSave *utils.WatchStore) {
 	// register signal handler
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	// handle signals
 	go func() {
@@ -32,7 +46,12 @@ func HandleSignals[T comparable](cancel func(), fileName string, toSave *utils.S
 		for sig := range c {
 			if !forced {
 				utils.RemoveOldItems(toSave, time.Now().UTC().Unix())
-				utils.Save(fileName, toSave)
+				if snapshotter != nil {
+					errSave := snapshotter.Save()
+					if errSave != nil {
+						log.Printf("failed to save watches: %s", errSave.Error())
+					}
+				}
 				log.Printf("Shutting down bot (%v)", sig)
 				cancel()
 			} else {
@@ -45,6 +64,198 @@ func HandleSignals[T comparable](cancel func(), fileName string, toSave *utils.S
 	}()
 }
 
+// SnapshotInterval reads how often the file storage is snapshotted, ie "5m", defaulting to five minutes
+func SnapshotInterval(raw string) (time.Duration, error) {
+	if len(raw) == 0 {
+		return 5 * time.Minute, nil
+	}
+	return time.ParseDuration(raw)
+}
+
+// LoadStorage fills the store from the storage picked by the STORAGE setting, returning the file to snapshot to
+// on shutdown. The default "file" storage is the gob file at filename, "sqlite" writes each change to sqliteFile
+// as it happens and imports the gob file the first time it is used.
+func LoadStorage(storageType string, filename string, sqliteFile string, store *utils.WatchStore) (string, storage.Storage, error) {
+	switch strings.ToLower(storageType) {
+	case "", "file":
+		errLoad := utils.Load(filename, store)
+		return filename, nil, errLoad
+	case "sqlite":
+		db, err := storage.NewSQLite(sqliteFile)
+		if err != nil {
+			return "", nil, err
+		}
+		watches, err := db.LoadWatches()
+		if err != nil {
+			return "", nil, err
+		}
+		for _, watchTx := range watches {
+			store.Add(watchTx)
+		}
+		store.SetPersister(db)
+		if len(watches) == 0 && len(filename) > 0 {
+			if _, errStat := os.Stat(filename); errStat == nil {
+				log.Printf("importing watches from %s into %s", filename, sqliteFile)
+				errLoad := utils.Load(filename, store)
+				if errLoad != nil {
+					return "", nil, errLoad
+				}
+			}
+		}
+		return "", db, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported storage: %s", storageType)
+	}
+}
+
+// ParseHeaders reads headers in the form "Name: value; Other-Name: value"
+func ParseHeaders(raw string) map[string]string {
+	headers := make(map[string]string)
+	for _, header := range strings.Split(raw, ";") {
+		name, value, found := strings.Cut(header, ":")
+		if !found {
+			continue
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return headers
+}
+
+// NewNotifier sends the messages to the frontend each watch was requested from, emails the addresses given with
+// notify: when SMTP_HOST is set and posts to the WEBHOOK_URLS when they are set. The policy returned is who the
+// frontends can accept notify: for, nobody without SMTP_HOST.
+func NewNotifier(frontends mempool.FrontendNotifiers) (mempool.Notifier, utils.NotifyPolicy, error) {
+	notifiers := mempool.Notifiers{frontends}
+	policy := utils.NotifyPolicy{}
+	if smtpHost := os.Getenv("SMTP_HOST"); len(smtpHost) > 0 {
+		var err error
+		policy, err = utils.NewNotifyPolicy(os.Getenv("SMTP_ALLOWED_DOMAINS"))
+		if err != nil {
+			return nil, policy, fmt.Errorf("invalid SMTP_ALLOWED_DOMAINS: %w", err)
+		}
+		if policy.AnyDomain {
+			log.Println("warning: SMTP_ALLOWED_DOMAINS is *, notify: can email any address")
+		}
+		mailer, err := email.NewMailer(email.Config{
+			Host:     smtpHost,
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+			StartTLS: os.Getenv("SMTP_STARTTLS") != "false",
+			Notify:   policy,
+		})
+		if err != nil {
+			return nil, policy, err
+		}
+		notifiers = append(notifiers, mailer)
+	}
+	rawUrls := os.Getenv("WEBHOOK_URLS")
+	if len(rawUrls) == 0 {
+		return notifiers, policy, nil
+	}
+	urls := []string{}
+	for _, url := range strings.Split(rawUrls, ",") {
+		if url = strings.TrimSpace(url); len(url) > 0 {
+			urls = append(urls, url)
+		}
+	}
+	attempts := 0
+	if rawAttempts := os.Getenv("WEBHOOK_ATTEMPTS"); len(rawAttempts) > 0 {
+		var err error
+		attempts, err = strconv.Atoi(rawAttempts)
+		if err != nil {
+			return nil, policy, fmt.Errorf("invalid WEBHOOK_ATTEMPTS: %w", err)
+		}
+	}
+	notifiers = append(notifiers, webhook.NewWebhook(webhook.Config{
+		URLs:           urls,
+		Secret:         os.Getenv("WEBHOOK_SECRET"),
+		Attempts:       attempts,
+		DeadLetterFile: os.Getenv("WEBHOOK_DEAD_LETTER_FILE"),
+	}))
+	return notifiers, policy, nil
+}
+
+// Explorers returns the explorer linked to for each network, the mempool deployment at EXPLORER_URL when it is set
+// or else the network's MEMPOOL_<NETWORK>_REST_URL instance, the public mempool.space is linked to otherwise
+func Explorers(networks []string) slackUtils.Explorers {
+	explorers := make(slackUtils.Explorers)
+	for _, network := range networks {
+		if base := os.Getenv("EXPLORER_URL"); len(base) > 0 {
+			explorers[utils.NormalizeNetwork(network)] = mempool.Explorer(base, network)
+			continue
+		}
+		restUrl := os.Getenv(fmt.Sprintf("MEMPOOL_%s_REST_URL", strings.ToUpper(network)))
+		if len(restUrl) > 0 {
+			explorers[utils.NormalizeNetwork(network)] = mempool.Instance{RestURL: restUrl}.ExplorerURL()
+		}
+	}
+	return explorers
+}
+
+// NewChainBackend picks the source of chain data from the CHAIN_BACKEND setting, defaulting to mempool.space
+func NewChainBackend(name string, networks []string) (mempool.ChainBackend, error) {
+	switch strings.ToLower(name) {
+	case "", "mempool":
+		instances := make(map[string]mempool.Instance)
+		for _, network := range networks {
+			prefix := fmt.Sprintf("MEMPOOL_%s_", strings.ToUpper(network))
+			restUrl := os.Getenv(prefix + "REST_URL")
+			if restUrl == "" {
+				continue
+			}
+			instances[network] = mempool.Instance{
+				RestURL:      restUrl,
+				WebsocketURL: os.Getenv(prefix + "WS_URL"),
+				Flavor:       os.Getenv(prefix + "FLAVOR"),
+				Headers:      ParseHeaders(os.Getenv(prefix + "HEADERS")),
+				CAFile:       os.Getenv(prefix + "CA_FILE"),
+			}
+		}
+		return mempool.NewMempoolSpace(instances)
+	case "bitcoind":
+		nodes := make(map[string]bitcoind.Node)
+		for _, network := range networks {
+			prefix := fmt.Sprintf("BITCOIND_%s_", strings.ToUpper(network))
+			rpcUrl := os.Getenv(prefix + "RPC_URL")
+			if rpcUrl == "" {
+				continue
+			}
+			nodes[network] = bitcoind.Node{
+				RPCURL:      rpcUrl,
+				RPCUser:     os.Getenv(prefix + "RPC_USER"),
+				RPCPassword: os.Getenv(prefix + "RPC_PASSWORD"),
+				ZMQAddress:  os.Getenv(prefix + "ZMQ_ADDRESS"),
+			}
+		}
+		if len(nodes) == 0 {
+			return nil, fmt.Errorf("no BITCOIND_<NETWORK>_RPC_URL set for any of the networks %s", strings.Join(networks, ", "))
+		}
+		return bitcoind.NewBitcoind(nodes), nil
+	case "electrum":
+		servers := make(map[string]electrum.Server)
+		for _, network := range networks {
+			prefix := fmt.Sprintf("ELECTRUM_%s_", strings.ToUpper(network))
+			address := os.Getenv(prefix + "ADDRESS")
+			if address == "" {
+				continue
+			}
+			servers[network] = electrum.Server{
+				Address:    address,
+				UseTLS:     os.Getenv(prefix+"TLS") == "true",
+				SkipVerify: os.Getenv(prefix+"SKIP_VERIFY") == "true",
+			}
+		}
+		if len(servers) == 0 {
+			return nil, fmt.Errorf("no ELECTRUM_<NETWORK>_ADDRESS set for any of the networks %s", strings.Join(networks, ", "))
+		}
+		return electrum.NewElectrum(servers), nil
+	default:
+		return nil, fmt.Errorf("unsupported chain backend: %s", name)
+	}
+}
+
 func main() {
 
 	//load .env variables
@@ -52,16 +263,31 @@ func main() {
 	token := os.Getenv("SLACK_AUTH_TOKEN")
 	appToken := os.Getenv("SLACK_APP_TOKEN")
 	filename := os.Getenv("SAVE_FILE")
+	if len(os.Args) > 1 && os.Args[1] == "state" {
+		errState := RunStateCommand(os.Args[2:], filename)
+		if errState != nil {
+			log.Fatalf(errState.Error())
+		}
+		return
+	}
 	networksToWatchRaw := os.Getenv("NETWORKS_TO_WATCH")
 	networksToWatch := strings.Split(networksToWatchRaw, ", ")
-	set := utils.NewSet[models.WatchTx]()
+	store := utils.NewWatchStore()
+
+	chainBackend, errBackend := NewChainBackend(os.Getenv("CHAIN_BACKEND"), networksToWatch)
+	if errBackend != nil {
+		log.Fatalf(errBackend.Error())
+	}
 
 	//load state of saved transactions
-	errLoad := utils.Load(filename, set)
+	snapshotFile, db, errLoad := LoadStorage(os.Getenv("STORAGE"), filename, os.Getenv("SQLITE_FILE"), store)
 	if errLoad != nil {
 		log.Fatalf(errLoad.Error())
 	}
-	utils.RemoveOldItems(set, time.Now().UTC().Unix())
+	if db != nil {
+		defer db.Close()
+	}
+	utils.RemoveOldItems(store, time.Now().UTC().Unix())
 
 	newBlock := make(chan models.NewBlock)
 	watchTransaction := make(chan models.WatchTx)
@@ -70,47 +296,132 @@ func main() {
 	defer cancelMempoolSpace()
 	defer close(newBlock)
 
-	//setup to gracefully handle shutdown from interupt signal
-	HandleSignals[models.WatchTx](cancelMempoolSpace, filename, set)
+	//keep the save file current while running, not only on shutdown
+	var snapshotter *utils.Snapshotter
+	if len(snapshotFile) > 0 {
+		interval, errInterval := SnapshotInterval(os.Getenv("SNAPSHOT_INTERVAL"))
+		if errInterval != nil {
+			log.Fatalf(errInterval.Error())
+		}
+		snapshotter = utils.NewSnapshotter(snapshotFile, store)
+		go snapshotter.Run(mempoolSpaceCtx, interval)
+	}
 
-	slackClient := slack.New(token, slack.OptionDebug(true), slack.OptionAppLevelToken(appToken))
+	//setup to gracefully handle shutdown from interupt & terminate signals
+	HandleSignals(cancelMempoolSpace, snapshotter, store)
+
+	//every chat frontend with a token set is started, they can all run at once
+	frontends := mempool.FrontendNotifiers{}
+	var slackClient *slack.Client
+	var slackModes slackUtils.MessageModes
+	explorers := Explorers(networksToWatch)
+	if len(token) > 0 {
+		var errModes error
+		slackModes, errModes = slackUtils.ParseMessageModes(os.Getenv("SLACK_MESSAGE_MODE"), os.Getenv("SLACK_CHANNEL_MODES"))
+		if errModes != nil {
+			log.Fatalf(errModes.Error())
+		}
+		slackClient = slack.New(token, slack.OptionDebug(true), slack.OptionAppLevelToken(appToken))
+		frontends[models.FrontendSlack] = slackUtils.NewNotifier(slackClient, explorers)
+	}
+	var discordSession *discordgo.Session
+	if discordToken := os.Getenv("DISCORD_BOT_TOKEN"); len(discordToken) > 0 {
+		var errDiscord error
+		discordSession, errDiscord = discord.NewSession(discordToken)
+		if errDiscord != nil {
+			log.Fatalf(errDiscord.Error())
+		}
+		frontends[models.FrontendDiscord] = discord.NewNotifier(discordSession)
+	}
+	var telegramBot *telegram.Bot
+	if telegramToken := os.Getenv("TELEGRAM_BOT_TOKEN"); len(telegramToken) > 0 {
+		telegramBot = telegram.NewBot(telegramToken)
+		frontends[models.FrontendTelegram] = telegram.NewNotifier(telegramBot)
+	}
+	var matrixClient *matrix.Client
+	if matrixToken := os.Getenv("MATRIX_ACCESS_TOKEN"); len(matrixToken) > 0 {
+		matrixClient = matrix.NewClient(os.Getenv("MATRIX_HOMESERVER_URL"), matrixToken)
+		matrixClient.UserID = os.Getenv("MATRIX_USER_ID")
+		errLogin := matrixClient.Login(context.Background())
+		if errLogin != nil {
+			log.Fatalf(errLogin.Error())
+		}
+		frontends[models.FrontendMatrix] = matrix.NewNotifier(matrixClient)
+	}
+	if len(frontends) == 0 {
+		log.Fatalf("no chat frontend is configured, set SLACK_AUTH_TOKEN & SLACK_APP_TOKEN, DISCORD_BOT_TOKEN, TELEGRAM_BOT_TOKEN or MATRIX_ACCESS_TOKEN")
+	}
+	notifier, notify, errNotifier := NewNotifier(frontends)
+	if errNotifier != nil {
+		log.Fatalf(errNotifier.Error())
+	}
 	listenUserTransCtx, cancelUserListen := context.WithCancel(mempoolSpaceCtx)
 	defer cancelUserListen()
 
 	//request initial state of saved transactions after a restart
-	if len(set.Keys()) > 0 {
+	if store.Len() > 0 {
 		for index := range networksToWatch {
 			curNetwork := networksToWatch[index]
-			lastHeight, err := mempool.GetLastBlockHeight(curNetwork)
+			lastHeight, err := chainBackend.GetLastBlockHeight(curNetwork)
 			if err != nil {
 				log.Fatalf(err.Error())
 			}
-			mempool.SendMessageForWatched(set, curNetwork, *lastHeight, slackClient)
+			mempool.SendMessageForWatched(chainBackend, store, curNetwork, *lastHeight, notifier)
 		}
 	}
 	for index := range networksToWatch { //loop through networks
 		curNetwork := networksToWatch[index]
 		//listen for new blocks on each chain
-		go mempool.ListenForBlocks(newBlock, curNetwork, mempoolSpaceCtx)
+		go chainBackend.ListenForBlocks(newBlock, curNetwork, mempoolSpaceCtx)
 	}
 	//update watched transactions as new block come in
-	go mempool.ListenForUserTrans(set, watchTransaction, newBlock, slackClient, listenUserTransCtx)
+	go mempool.ListenForUserTrans(chainBackend, store, watchTransaction, newBlock, notifier, listenUserTransCtx)
 
-	socketClient := socketmode.New(
-		slackClient,
-		socketmode.OptionDebug(true),
-		socketmode.OptionLog(log.New(os.Stdout, "socketmode: ", log.Lshortfile|log.LstdFlags)),
-	)
+	if slackClient != nil {
+		socketClient := socketmode.New(
+			slackClient,
+			socketmode.OptionDebug(true),
+			socketmode.OptionLog(log.New(os.Stdout, "socketmode: ", log.Lshortfile|log.LstdFlags)),
+		)
 
-	slackContext, slackCancel := context.WithCancel(mempoolSpaceCtx)
-	defer slackCancel()
+		slackContext, slackCancel := context.WithCancel(mempoolSpaceCtx)
+		defer slackCancel()
 
-	//listen for new slack messages and add transactions to ones that are watched
-	go slackUtils.ListenForSlackMessages(slackContext, slackClient, socketClient, watchTransaction)
+		//listen for new slack messages and add transactions to ones that are watched
+		go slackUtils.ListenForSlackMessages(slackContext, slackClient, socketClient, watchTransaction, store, slackModes, notify, explorers)
 
-	errRun := socketClient.RunContext(mempoolSpaceCtx)
-	if errRun != nil {
-		log.Fatal(errRun)
+		go func() {
+			errRun := socketClient.RunContext(mempoolSpaceCtx)
+			if errRun != nil {
+				log.Fatal(errRun)
+			}
+		}()
+	}
+
+	if discordSession != nil {
+		//listen for mentions of the bot on discord
+		go func() {
+			errRun := discord.ListenForDiscordMessages(mempoolSpaceCtx, discordSession, watchTransaction, store, notify)
+			if errRun != nil {
+				log.Fatal(errRun)
+			}
+		}()
+	}
+
+	if telegramBot != nil {
+		//long poll telegram for commands sent to the bot
+		go telegram.ListenForTelegramMessages(mempoolSpaceCtx, telegramBot, watchTransaction, store, notify)
+	}
+
+	if matrixClient != nil {
+		//sync with the matrix homeserver for invites and mentions
+		go func() {
+			errRun := matrix.ListenForMatrixMessages(mempoolSpaceCtx, matrixClient, watchTransaction, store, notify)
+			if errRun != nil {
+				log.Fatal(errRun)
+			}
+		}()
 	}
 
+	<-mempoolSpaceCtx.Done()
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tx-tracker/pkg/models"
+	"tx-tracker/pkg/utils"
+)
+
+func TestNewChainBackendBitcoindSkipsUnconfiguredNetworks(t *testing.T) {
+	t.Setenv("BITCOIND_MAINNET_RPC_URL", "http://127.0.0.1:8332")
+	t.Setenv("BITCOIND_TESTNET_RPC_URL", "")
+	_, err := NewChainBackend("bitcoind", []string{"mainnet", "testnet"})
+	if err != nil {
+		t.Errorf("got %s with mainnet configured", err)
+	}
+
+	t.Setenv("BITCOIND_MAINNET_RPC_URL", "")
+	_, err = NewChainBackend("bitcoind", []string{"mainnet", "testnet"})
+	if err == nil {
+		t.Errorf("no error with none of the networks configured")
+	}
+}
+
+func TestNewChainBackendElectrumSkipsUnconfiguredNetworks(t *testing.T) {
+	t.Setenv("ELECTRUM_MAINNET_ADDRESS", "")
+	t.Setenv("ELECTRUM_TESTNET_ADDRESS", "127.0.0.1:60002")
+	_, err := NewChainBackend("electrum", []string{"mainnet", "testnet"})
+	if err != nil {
+		t.Errorf("got %s with testnet configured", err)
+	}
+
+	t.Setenv("ELECTRUM_TESTNET_ADDRESS", "")
+	_, err = NewChainBackend("electrum", []string{"mainnet", "testnet"})
+	if err == nil {
+		t.Errorf("no error with none of the networks configured")
+	}
+}
+
+// the gob file is imported into a new sqlite database once, after that the database is what's loaded
+func TestLoadStorageImportsGobFileOnce(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "watches.gob")
+	sqliteFile := filepath.Join(dir, "watches.db")
+	now := time.Now().UTC().Unix()
+	saved := utils.NewWatchStore()
+	saved.Add(models.WatchTx{TxID: "aa", Channel: "C1", Confs: 6, TimeRequested: now})
+	saved.Add(models.WatchTx{TxID: "bb", Channel: "C1", Network: "testnet", Confs: 3, TimeRequested: now})
+	if err := utils.Save(filename, saved); err != nil {
+		t.Fatalf("Save failed: %s", err)
+	}
+
+	store := utils.NewWatchStore()
+	snapshotFile, db, err := LoadStorage("sqlite", filename, sqliteFile, store)
+	if err != nil {
+		t.Fatalf("LoadStorage failed: %s", err)
+	}
+	if snapshotFile != "" {
+		t.Errorf("got snapshot file %q, sqlite storage shouldn't snapshot", snapshotFile)
+	}
+	if store.Len() != 2 {
+		t.Fatalf("got %d watches, want the 2 in the gob file", store.Len())
+	}
+	//the imported watches were written to the database, finishing one there has to stick across a restart
+	store.Remove("mainnet:aa:C1", models.FinishCompleted)
+	db.Close()
+
+	store = utils.NewWatchStore()
+	_, db, err = LoadStorage("sqlite", filename, sqliteFile, store)
+	if err != nil {
+		t.Fatalf("LoadStorage failed: %s", err)
+	}
+	defer db.Close()
+	watches := store.All()
+	if len(watches) != 1 || watches[0].ID != "testnet:bb:C1" || watches[0].Confs != 3 {
+		t.Errorf("got %+v, want only the unfinished watch from the database", watches)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"tx-tracker/pkg/utils"
+)
+
+const stateUsage = `usage:
+  tx-tracker state export [-file SAVE_FILE] [-o out.json]   write the save file as JSON, to stdout without -o
+  tx-tracker state import [-file SAVE_FILE] in.json         replace the save file with the watches in in.json, - reads stdin`
+
+// RunStateCommand runs "tx-tracker state export|import", converting the save file to and from JSON for inspecting
+// and repairing it by hand. The bot should be stopped first, it overwrites the save file when it shuts down.
+func RunStateCommand(args []string, saveFile string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(stateUsage)
+	}
+	flags := flag.NewFlagSet("state "+args[0], flag.ContinueOnError)
+	file := flags.String("file", saveFile, "save file to convert")
+	switch args[0] {
+	case "export":
+		out := flags.String("o", "", "JSON file to write, stdout when empty")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		return ExportStateFile(*file, *out)
+	case "import":
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return fmt.Errorf(stateUsage)
+		}
+		return ImportStateFile(flags.Arg(0), *file)
+	default:
+		return fmt.Errorf(stateUsage)
+	}
+}
+
+// ExportStateFile writes the watches in saveFile to out as JSON, migrating older save files on the way
+func ExportStateFile(saveFile string, out string) error {
+	if len(saveFile) == 0 {
+		return fmt.Errorf("no save file, set SAVE_FILE or pass -file")
+	}
+	fi, err := os.Open(saveFile)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+	state, err := utils.ReadState(fi)
+	if err != nil {
+		return err
+	}
+	if len(out) == 0 {
+		return utils.ExportState(os.Stdout, state)
+	}
+	fo, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer fo.Close()
+	err = utils.ExportState(fo, state)
+	if err != nil {
+		return err
+	}
+	return fo.Close()
+}
+
+// ImportStateFile replaces saveFile with the watches in the JSON file in, "-" reads stdin
+func ImportStateFile(in string, saveFile string) error {
+	if len(saveFile) == 0 {
+		return fmt.Errorf("no save file, set SAVE_FILE or pass -file")
+	}
+	var r io.Reader = os.Stdin
+	if in != "-" {
+		fi, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer fi.Close()
+		r = fi
+	}
+	state, err := utils.ImportState(r)
+	if err != nil {
+		return err
+	}
+	store := utils.NewWatchStore()
+	for _, watchTx := range state.Watches {
+		if _, added := store.Add(watchTx); !added {
+			fmt.Fprintf(os.Stderr, "skipping duplicate watch %s\n", utils.WatchID(watchTx))
+		}
+	}
+	err = utils.Save(saveFile, store)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "imported %d watches into %s\n", store.Len(), saveFile)
+	return nil
+}
@@ -0,0 +1,431 @@
+package bitcoind
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"tx-tracker/pkg/models"
+	"tx-tracker/pkg/utils"
+
+	"github.com/go-zeromq/zmq4"
+)
+
+// rpc error code bitcoind returns when a transaction is not in the mempool and there is no txindex
+const rpcInvalidAddressOrKey = -5
+
+// Node holds the connection settings for the bitcoind serving a single network
+type Node struct {
+	RPCURL      string
+	RPCUser     string
+	RPCPassword string
+	ZMQAddress  string
+}
+
+// Bitcoind is the ChainBackend backed by our own bitcoind, blocks come from the ZMQ feed and tx status from JSON-RPC
+type Bitcoind struct {
+	nodes  map[string]Node
+	client *http.Client
+	nextId int
+	// outputs are the spendable output indexes of the transactions seen in the mempool, keyed by outputsKey. Without
+	// -txindex a confirmed transaction can only be found through whichever of them are still unspent
+	outputs map[string][]int
+	mutex   sync.Mutex
+}
+
+type rpcRequest struct {
+	JsonRPC string        `json:"jsonrpc"`
+	Id      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+	Id     int             `json:"id"`
+}
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("bitcoind rpc error %d: %s", e.Code, e.Message)
+}
+
+type rawTransaction struct {
+	TxId          string     `json:"txid"`
+	BlockHash     string     `json:"blockhash"`
+	Confirmations int        `json:"confirmations"`
+	VSize         int        `json:"vsize"`
+	Vin           []txInput  `json:"vin"`
+	Vout          []txOutput `json:"vout"`
+}
+
+type txInput struct {
+	TxId string `json:"txid"`
+	Vout int    `json:"vout"`
+}
+
+type txOutput struct {
+	N            int `json:"n"`
+	ScriptPubKey struct {
+		Type string `json:"type"`
+	} `json:"scriptPubKey"`
+}
+
+type mempoolEntry struct {
+	VSize int `json:"vsize"`
+	Fees  struct {
+		Base float64 `json:"base"`
+	} `json:"fees"`
+}
+
+type txOut struct {
+	BestBlock     string `json:"bestblock"`
+	Confirmations int    `json:"confirmations"`
+}
+
+type blockHeader struct {
+	Hash              string `json:"hash"`
+	Height            int    `json:"height"`
+	Time              int    `json:"time"`
+	PreviousBlockHash string `json:"previousblockhash"`
+}
+
+func NewBitcoind(nodes map[string]Node) *Bitcoind {
+	return &Bitcoind{
+		nodes:   nodes,
+		client:  &http.Client{Timeout: time.Second * 30},
+		outputs: map[string][]int{},
+	}
+}
+
+func (b *Bitcoind) GetLastBlockHeight(network string) (*int, error) {
+	var height int
+	err := b.call(network, "getblockcount", []interface{}{}, &height)
+	if err != nil {
+		log.Printf("failed to get block count from bitcoind: %s", err.Error())
+		return nil, err
+	}
+	log.Printf("\nLast Block Height %d", height)
+	return &height, nil
+}
+
+func (b *Bitcoind) CheckTransactionWasConfirmed(txId string, network string) (*models.ConfirmedPayload, error) {
+	blockHash, err := b.findConfirmingBlock(txId, network)
+	if err != nil {
+		return nil, err
+	}
+	if blockHash == "" {
+		return &models.ConfirmedPayload{Confirmed: false}, nil
+	}
+	header, err := b.getBlockHeader(blockHash, network)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ConfirmedPayload{
+		Confirmed:   true,
+		BlockHeight: &header.Height,
+		BlockHash:   &header.Hash,
+		BlockTime:   &header.Time,
+	}, nil
+}
+
+func (b *Bitcoind) GetTransaction(txId string, network string) (*models.TxInfo, error) {
+	rawTx := rawTransaction{}
+	err := b.call(network, "getrawtransaction", []interface{}{txId, true}, &rawTx)
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) && rpcErr.Code == rpcInvalidAddressOrKey {
+		//not in the mempool, without -txindex the utxo set is the only other place to look
+		blockHash, errBlock := b.findConfirmingBlock(txId, network)
+		if errBlock != nil {
+			return nil, errBlock
+		}
+		if blockHash == "" {
+			return nil, models.ErrTxNotFound
+		}
+		return &models.TxInfo{TxID: txId, Confirmed: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.rememberOutputs(network, rawTx)
+	info := &models.TxInfo{TxID: rawTx.TxId, VSize: rawTx.VSize, Confirmed: rawTx.BlockHash != ""}
+	for _, in := range rawTx.Vin {
+		info.Inputs = append(info.Inputs, models.Outpoint{TxID: in.TxId, Vout: in.Vout})
+	}
+	if info.Confirmed {
+		return info, nil
+	}
+	entry := mempoolEntry{}
+	errEntry := b.call(network, "getmempoolentry", []interface{}{txId}, &entry)
+	if errEntry != nil {
+		return nil, errEntry
+	}
+	info.Fee = int64(math.Round(entry.Fees.Base * 100_000_000))
+	return info, nil
+}
+
+type spendingPrevout struct {
+	TxId         string `json:"txid"`
+	Vout         int    `json:"vout"`
+	SpendingTxId string `json:"spendingtxid"`
+}
+
+// GetReplacement only finds replacements still in the mempool, bitcoind has no index of spends in the chain
+func (b *Bitcoind) GetReplacement(txId string, network string, inputs []models.Outpoint) (string, error) {
+	if len(inputs) == 0 {
+		return "", nil
+	}
+	prevouts := make([]map[string]interface{}, len(inputs))
+	for i, input := range inputs {
+		prevouts[i] = map[string]interface{}{"txid": input.TxID, "vout": input.Vout}
+	}
+	var spending []spendingPrevout
+	err := b.call(network, "gettxspendingprevout", []interface{}{prevouts}, &spending)
+	if err != nil {
+		return "", err
+	}
+	for _, spend := range spending {
+		if len(spend.SpendingTxId) > 0 && spend.SpendingTxId != txId {
+			return spend.SpendingTxId, nil
+		}
+	}
+	return "", nil
+}
+
+// findConfirmingBlock returns the hash of the block holding the transaction, or an empty string while it is unconfirmed
+func (b *Bitcoind) findConfirmingBlock(txId string, network string) (string, error) {
+	rawTx := rawTransaction{}
+	err := b.call(network, "getrawtransaction", []interface{}{txId, true}, &rawTx)
+	if err == nil {
+		b.rememberOutputs(network, rawTx)
+		return rawTx.BlockHash, nil
+	}
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != rpcInvalidAddressOrKey {
+		return "", err
+	}
+	//without -txindex only mempool transactions can be looked up, fall back to the utxo set for confirmed ones.
+	//Any output still unspent gives the block away, so every output seen while it was in the mempool is tried
+	log.Printf("getrawtransaction could not find %s, falling back to gettxout", txId)
+	var out *txOut
+	for _, vout := range b.knownOutputs(network, txId) {
+		out = &txOut{}
+		errOut := b.call(network, "gettxout", []interface{}{txId, vout, false}, &out)
+		if errOut != nil {
+			return "", errOut
+		}
+		if out != nil && out.Confirmations > 0 {
+			break
+		}
+	}
+	if out == nil || out.Confirmations == 0 {
+		return "", nil
+	}
+	b.forgetOutputs(network, txId)
+	best, err := b.getBlockHeader(out.BestBlock, network)
+	if err != nil {
+		return "", err
+	}
+	var blockHash string
+	errHash := b.call(network, "getblockhash", []interface{}{best.Height - out.Confirmations + 1}, &blockHash)
+	if errHash != nil {
+		return "", errHash
+	}
+	return blockHash, nil
+}
+
+func outputsKey(network string, txId string) string {
+	return network + ":" + txId
+}
+
+// rememberOutputs keeps the spendable outputs of a transaction in the mempool, OP_RETURN outputs never make it
+// into the utxo set so are left out
+func (b *Bitcoind) rememberOutputs(network string, rawTx rawTransaction) {
+	if rawTx.BlockHash != "" {
+		b.forgetOutputs(network, rawTx.TxId)
+		return
+	}
+	vouts := []int{}
+	for _, output := range rawTx.Vout {
+		if output.ScriptPubKey.Type != "nulldata" {
+			vouts = append(vouts, output.N)
+		}
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.outputs[outputsKey(network, rawTx.TxId)] = vouts
+}
+
+// knownOutputs returns the outputs to look for in the utxo set, only the first is known for transactions that
+// were never seen in the mempool
+func (b *Bitcoind) knownOutputs(network string, txId string) []int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	vouts, ok := b.outputs[outputsKey(network, txId)]
+	if !ok {
+		return []int{0}
+	}
+	return vouts
+}
+
+func (b *Bitcoind) forgetOutputs(network string, txId string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.outputs, outputsKey(network, txId))
+}
+
+func (b *Bitcoind) getBlockHeader(blockHash string, network string) (*blockHeader, error) {
+	header := &blockHeader{}
+	err := b.call(network, "getblockheader", []interface{}{blockHash, true}, header)
+	if err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// node returns the settings for the network, watches on mainnet are stored with an empty network
+func (b *Bitcoind) node(network string) (Node, bool) {
+	if network == "" {
+		network = "mainnet"
+	}
+	node, ok := b.nodes[network]
+	return node, ok
+}
+
+func (b *Bitcoind) ListenForBlocks(newBlock chan models.NewBlock, network string, ctx context.Context) {
+	node, ok := b.node(network)
+	if !ok {
+		log.Printf("no bitcoind configured for network %s", network)
+		return
+	}
+	for {
+		err := b.listenZMQ(newBlock, network, node.ZMQAddress, ctx)
+		select {
+		case <-ctx.Done():
+			log.Printf("Shutting down bitcoind zmq listener for %s", network)
+			return
+		default:
+		}
+		log.Printf("bitcoind zmq connection lost (%v), re-creating and continuing to listen", err)
+		time.Sleep(time.Second * 5)
+	}
+}
+
+func (b *Bitcoind) listenZMQ(newBlock chan models.NewBlock, network string, address string, ctx context.Context) error {
+	sub := zmq4.NewSub(ctx)
+	defer sub.Close()
+
+	log.Printf("\nconnecting to bitcoind zmq: %s", address)
+	err := sub.Dial(address)
+	if err != nil {
+		return err
+	}
+	for _, topic := range []string{"hashblock", "rawblock"} {
+		errSub := sub.SetOption(zmq4.OptionSubscribe, topic)
+		if errSub != nil {
+			return errSub
+		}
+	}
+	log.Printf("listening to the blocks event from bitcoind")
+
+	eventNetwork := network
+	if eventNetwork == "mainnet" {
+		eventNetwork = ""
+	}
+	lastHash := ""
+	for {
+		msg, errRecv := sub.Recv()
+		if errRecv != nil {
+			return errRecv
+		}
+		if len(msg.Frames) < 2 {
+			continue
+		}
+		blockHash := ""
+		switch string(msg.Frames[0]) {
+		case "hashblock":
+			blockHash = hex.EncodeToString(msg.Frames[1])
+		case "rawblock":
+			if len(msg.Frames[1]) < 80 {
+				continue
+			}
+			blockHash = utils.HashBlockHeader(msg.Frames[1][:80])
+		default:
+			continue
+		}
+		//bitcoind publishes both topics for the same block when both are enabled
+		if blockHash == lastHash {
+			continue
+		}
+		lastHash = blockHash
+		header, errHeader := b.getBlockHeader(blockHash, network)
+		if errHeader != nil {
+			log.Printf("\nerror while looking up block header %s: %s", blockHash, errHeader.Error())
+			continue
+		}
+		log.Printf("\nnew block %s at height %d", header.Hash, header.Height)
+		newBlock <- models.NewBlock{IsNew: true, Network: eventNetwork, BlockHeight: header.Height, BlockHash: header.Hash, PreviousBlockHash: header.PreviousBlockHash}
+	}
+}
+
+func (b *Bitcoind) call(network string, method string, params []interface{}, result interface{}) error {
+	node, ok := b.node(network)
+	if !ok {
+		return fmt.Errorf("no bitcoind configured for network %s", network)
+	}
+	b.mutex.Lock()
+	b.nextId++
+	id := b.nextId
+	b.mutex.Unlock()
+
+	body, err := json.Marshal(rpcRequest{JsonRPC: "1.0", Id: id, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, node.RPCURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(node.RPCUser) > 0 {
+		req.SetBasicAuth(node.RPCUser, node.RPCPassword)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		log.Printf("failed to request out to bitcoind")
+		return err
+	}
+	defer resp.Body.Close()
+	raw, readErr := io.ReadAll(resp.Body)
+	if readErr != nil {
+		log.Printf("failed to read body from response of bitcoind")
+		return readErr
+	}
+	//bitcoind answers rpc errors with a 404/500 status but still includes the json error body
+	rpcResp := rpcResponse{}
+	errMarshal := json.Unmarshal(raw, &rpcResp)
+	if errMarshal != nil {
+		return fmt.Errorf("bitcoind %s returned %s: %s", method, resp.Status, strings.TrimSpace(string(raw)))
+	}
+	if rpcResp.Error != nil {
+		return rpcResp.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(rpcResp.Result, result)
+}
@@ -0,0 +1,250 @@
+package bitcoind
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"tx-tracker/pkg/models"
+)
+
+const (
+	testTxId      = "1111111111111111111111111111111111111111111111111111111111111111"
+	testBlockHash = "000000000000000000000000000000000000000000000000000000000000bbbb"
+	testBestHash  = "000000000000000000000000000000000000000000000000000000000000cccc"
+)
+
+// rpcHandler answers a single JSON-RPC method, returning the result or the rpc error
+type rpcHandler func(params []interface{}) (interface{}, *RPCError)
+
+// stubNode serves the methods like bitcoind's JSON-RPC, recording every call it gets
+type stubNode struct {
+	t       *testing.T
+	methods map[string]rpcHandler
+	mutex   sync.Mutex
+	calls   []string
+}
+
+func newStubNode(t *testing.T, methods map[string]rpcHandler) (*Bitcoind, *stubNode) {
+	stub := &stubNode{t: t, methods: methods}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	return NewBitcoind(map[string]Node{"mainnet": {RPCURL: server.URL, RPCUser: "user", RPCPassword: "pass"}}), stub
+}
+
+func (s *stubNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+		s.t.Errorf("request without the rpc credentials")
+	}
+	req := rpcRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.t.Fatalf("failed to decode the rpc request: %s", err)
+	}
+	s.mutex.Lock()
+	s.calls = append(s.calls, rpcCall(req.Method, req.Params...))
+	s.mutex.Unlock()
+	handler, ok := s.methods[req.Method]
+	if !ok {
+		s.t.Errorf("unexpected call to %s", req.Method)
+		http.Error(w, "unexpected method", http.StatusInternalServerError)
+		return
+	}
+	result, rpcErr := handler(req.Params)
+	//bitcoind answers errors with a 500 and the error in the body, like it does for -5
+	status := http.StatusOK
+	if rpcErr != nil {
+		status = http.StatusInternalServerError
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"result": result, "error": rpcErr, "id": req.Id})
+}
+
+// rpcCall formats a call as it's recorded, ie "gettxout <txid> 1 false"
+func rpcCall(method string, params ...interface{}) string {
+	return strings.TrimSpace(fmt.Sprintln(append([]interface{}{method}, params...)...))
+}
+
+func (s *stubNode) called(call string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, made := range s.calls {
+		if made == call {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *stubNode) calledMethod(method string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, made := range s.calls {
+		if strings.HasPrefix(made, method+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+func notFound(params []interface{}) (interface{}, *RPCError) {
+	return nil, &RPCError{Code: rpcInvalidAddressOrKey, Message: "No such mempool or blockchain transaction. Use gettransaction for wallet transactions."}
+}
+
+func headers(byHash map[string]blockHeader) rpcHandler {
+	return func(params []interface{}) (interface{}, *RPCError) {
+		header, ok := byHash[params[0].(string)]
+		if !ok {
+			return nil, &RPCError{Code: rpcInvalidAddressOrKey, Message: "Block not found"}
+		}
+		return header, nil
+	}
+}
+
+func TestGetTransactionInMempool(t *testing.T) {
+	backend, _ := newStubNode(t, map[string]rpcHandler{
+		"getrawtransaction": func(params []interface{}) (interface{}, *RPCError) {
+			return map[string]interface{}{
+				"txid":  testTxId,
+				"vsize": 141,
+				"vin":   []map[string]interface{}{{"txid": testBestHash, "vout": 2}},
+				"vout":  []map[string]interface{}{{"n": 0, "scriptPubKey": map[string]string{"type": "witness_v0_keyhash"}}},
+			}, nil
+		},
+		"getmempoolentry": func(params []interface{}) (interface{}, *RPCError) {
+			return map[string]interface{}{"vsize": 141, "fees": map[string]float64{"base": 0.00002115}}, nil
+		},
+	})
+	info, err := backend.GetTransaction(testTxId, "mainnet")
+	if err != nil {
+		t.Fatalf("GetTransaction failed: %s", err)
+	}
+	if info.Confirmed || info.VSize != 141 || info.Fee != 2115 {
+		t.Errorf("got confirmed %v, vsize %d & fee %d, want false, 141 & 2115", info.Confirmed, info.VSize, info.Fee)
+	}
+	if len(info.Inputs) != 1 || info.Inputs[0] != (models.Outpoint{TxID: testBestHash, Vout: 2}) {
+		t.Errorf("got inputs %v", info.Inputs)
+	}
+}
+
+func TestCheckTransactionWasConfirmedWithTxIndex(t *testing.T) {
+	backend, stub := newStubNode(t, map[string]rpcHandler{
+		"getrawtransaction": func(params []interface{}) (interface{}, *RPCError) {
+			return map[string]interface{}{"txid": testTxId, "blockhash": testBlockHash, "confirmations": 2}, nil
+		},
+		"getblockheader": headers(map[string]blockHeader{testBlockHash: {Hash: testBlockHash, Height: 800000, Time: 1690000000}}),
+	})
+	confirmed, err := backend.CheckTransactionWasConfirmed(testTxId, "mainnet")
+	if err != nil {
+		t.Fatalf("CheckTransactionWasConfirmed failed: %s", err)
+	}
+	if !confirmed.Confirmed || *confirmed.BlockHeight != 800000 || *confirmed.BlockHash != testBlockHash || *confirmed.BlockTime != 1690000000 {
+		t.Errorf("got %+v", confirmed)
+	}
+	if stub.calledMethod("gettxout") {
+		t.Errorf("the utxo set was looked at when getrawtransaction found the transaction")
+	}
+}
+
+// without -txindex a mined transaction is a -5, it's found through whichever of its outputs is still unspent
+func TestCheckTransactionWasConfirmedFallsBackToGetTxOut(t *testing.T) {
+	mined := false
+	backend, stub := newStubNode(t, map[string]rpcHandler{
+		"getrawtransaction": func(params []interface{}) (interface{}, *RPCError) {
+			if mined {
+				return notFound(params)
+			}
+			return map[string]interface{}{
+				"txid": testTxId,
+				"vout": []map[string]interface{}{
+					{"n": 0, "scriptPubKey": map[string]string{"type": "nulldata"}},
+					{"n": 1, "scriptPubKey": map[string]string{"type": "witness_v0_keyhash"}},
+					{"n": 2, "scriptPubKey": map[string]string{"type": "witness_v1_taproot"}},
+				},
+			}, nil
+		},
+		"getmempoolentry": func(params []interface{}) (interface{}, *RPCError) {
+			return map[string]interface{}{"vsize": 200, "fees": map[string]float64{"base": 0.00001}}, nil
+		},
+		"gettxout": func(params []interface{}) (interface{}, *RPCError) {
+			//the first spendable output was already spent, gettxout answers null for it
+			if params[1].(float64) == 2 {
+				return map[string]interface{}{"bestblock": testBestHash, "confirmations": 3}, nil
+			}
+			return nil, nil
+		},
+		"getblockheader": headers(map[string]blockHeader{
+			testBestHash:  {Hash: testBestHash, Height: 800002},
+			testBlockHash: {Hash: testBlockHash, Height: 800000, Time: 1690000000},
+		}),
+		"getblockhash": func(params []interface{}) (interface{}, *RPCError) {
+			if params[0].(float64) != 800000 {
+				t.Errorf("asked for the block at %v, want 800000", params[0])
+			}
+			return testBlockHash, nil
+		},
+	})
+
+	_, err := backend.GetTransaction(testTxId, "mainnet")
+	if err != nil {
+		t.Fatalf("GetTransaction failed: %s", err)
+	}
+	mined = true
+	confirmed, err := backend.CheckTransactionWasConfirmed(testTxId, "mainnet")
+	if err != nil {
+		t.Fatalf("CheckTransactionWasConfirmed failed: %s", err)
+	}
+	if !confirmed.Confirmed || *confirmed.BlockHeight != 800000 || *confirmed.BlockHash != testBlockHash {
+		t.Errorf("got %+v", confirmed)
+	}
+	if stub.called(rpcCall("gettxout", testTxId, 0, false)) {
+		t.Errorf("the OP_RETURN output was looked up in the utxo set")
+	}
+	if !stub.called(rpcCall("gettxout", testTxId, 1, false)) {
+		t.Errorf("the first spendable output wasn't looked up")
+	}
+}
+
+func TestGetTransactionUnknown(t *testing.T) {
+	backend, stub := newStubNode(t, map[string]rpcHandler{
+		"getrawtransaction": notFound,
+		"gettxout": func(params []interface{}) (interface{}, *RPCError) {
+			return nil, nil
+		},
+	})
+	_, err := backend.GetTransaction(testTxId, "")
+	if !errors.Is(err, models.ErrTxNotFound) {
+		t.Errorf("got %v, want models.ErrTxNotFound", err)
+	}
+	//never seen in the mempool, so only its first output is known to look for
+	if !stub.called(rpcCall("gettxout", testTxId, 0, false)) {
+		t.Errorf("the first output wasn't looked up")
+	}
+	confirmed, err := backend.CheckTransactionWasConfirmed(testTxId, "")
+	if err != nil {
+		t.Fatalf("CheckTransactionWasConfirmed failed: %s", err)
+	}
+	if confirmed.Confirmed {
+		t.Errorf("an unknown transaction was confirmed")
+	}
+}
+
+func TestCallReturnsOtherRPCErrors(t *testing.T) {
+	backend, _ := newStubNode(t, map[string]rpcHandler{
+		"getrawtransaction": func(params []interface{}) (interface{}, *RPCError) {
+			return nil, &RPCError{Code: -28, Message: "Loading block index..."}
+		},
+	})
+	_, err := backend.GetTransaction(testTxId, "mainnet")
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != -28 {
+		t.Errorf("got %v, want the -28 rpc error", err)
+	}
+	if errors.Is(err, models.ErrTxNotFound) {
+		t.Errorf("a node still starting up was taken as the transaction not existing")
+	}
+}
@@ -0,0 +1,167 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"tx-tracker/pkg/models"
+	"tx-tracker/pkg/utils"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// embed colors, the same green and red as the slack attachments
+const (
+	ColorOk    = 0x4af030
+	ColorError = 0xef3232
+)
+
+// Intents are the gateway events the bot needs, reading the text of mentions needs the privileged message content
+// intent enabled in the developer portal
+const Intents = discordgo.IntentsGuildMessages | discordgo.IntentsDirectMessages | discordgo.IntentsMessageContent
+
+func NewSession(token string) (*discordgo.Session, error) {
+	session, err := discordgo.New("Bot " + token)
+	if err != nil {
+		return nil, err
+	}
+	session.Identify.Intents = Intents
+	return session, nil
+}
+
+// ListenForDiscordMessages connects to the gateway and handles mentions of the bot and button clicks until ctx is done
+func ListenForDiscordMessages(ctx context.Context, session *discordgo.Session, watchTransaction chan models.WatchTx, store *utils.WatchStore, notify utils.NotifyPolicy) error {
+	session.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
+		if m.Author == nil || m.Author.Bot || !mentionsBot(s, m.Message) {
+			return
+		}
+		err := HandleMentionToBot(m.Message, s, watchTransaction, store, notify)
+		if err != nil {
+			log.Printf("failed to handle discord message: %s", err.Error())
+		}
+	})
+	session.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		err := HandleInteraction(i, s, watchTransaction, store)
+		if err != nil {
+			log.Printf("failed to handle discord interaction: %s", err.Error())
+		}
+	})
+	err := session.Open()
+	if err != nil {
+		return fmt.Errorf("failed to connect to discord: %w", err)
+	}
+	<-ctx.Done()
+	log.Println("Shutting down discord listener")
+	return session.Close()
+}
+
+func mentionsBot(s *discordgo.Session, message *discordgo.Message) bool {
+	if s.State == nil || s.State.User == nil {
+		return false
+	}
+	for _, user := range message.Mentions {
+		if user.ID == s.State.User.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func HandleMentionToBot(message *discordgo.Message, s *discordgo.Session, watchTransaction chan models.WatchTx, store *utils.WatchStore, notify utils.NotifyPolicy) error {
+
+	switch utils.ParseCommand(message.Content) {
+	case utils.CommandList:
+		return HandleListCommand(message.ChannelID, s, store)
+	case utils.CommandUnwatch:
+		watchTx, errConv := utils.ParseMessage(message.Content)
+		if errConv != nil {
+			return PostError(message.ChannelID, fmt.Sprintf("Failed to stop watching, check your format? %s", errConv), s)
+		}
+		return HandleUnwatch(message.ChannelID, utils.WatchSubject(*watchTx), s, store)
+	case utils.CommandUpdate:
+		watchTx, errConv := utils.ParseUpdate(message.Content)
+		if errConv != nil {
+			return PostError(message.ChannelID, fmt.Sprintf("Failed to update watcher, check your format? %s", errConv), s)
+		}
+		return HandleUpdate(message.ChannelID, utils.WatchSubject(*watchTx), watchTx.Confs, s, watchTransaction, store)
+	}
+
+	watchTx, errConv := utils.ParseWatch(message.Content, notify)
+	if errConv != nil {
+		return PostError(message.ChannelID, fmt.Sprintf("Failed to setup watcher, check your format? %s", errConv), s)
+	}
+	watchTx.Channel = message.ChannelID
+	watchTx.Frontend = models.FrontendDiscord
+	watchTransaction <- *watchTx
+	return PostEmbed(message.ChannelID, utils.WatchingText(*watchTx), ColorOk, s)
+}
+
+func HandleListCommand(channel string, s *discordgo.Session, store *utils.WatchStore) error {
+	return PostEmbed(channel, utils.ListText(store, models.FrontendDiscord, channel), ColorOk, s)
+}
+
+func HandleUnwatch(channel string, id string, s *discordgo.Session, store *utils.WatchStore) error {
+	removed := utils.RemoveWatches(store, models.FrontendDiscord, channel, id)
+	if len(removed) == 0 {
+		return PostError(channel, utils.NotWatchedText(models.FrontendDiscord, id), s)
+	}
+	return PostEmbed(channel, utils.UnwatchedText(id), ColorOk, s)
+}
+
+func HandleUpdate(channel string, id string, confs int, s *discordgo.Session, watchTransaction chan models.WatchTx, store *utils.WatchStore) error {
+	updated := utils.UpdateWatchConfs(store, models.FrontendDiscord, channel, id, confs, watchTransaction)
+	if len(updated) == 0 {
+		return PostError(channel, utils.NotWatchedText(models.FrontendDiscord, id), s)
+	}
+	return PostEmbed(channel, utils.UpdatedText(id, confs, updated[0].ConfsCount), ColorOk, s)
+}
+
+func PostError(channel string, text string, s *discordgo.Session) error {
+	return PostEmbed(channel, text, ColorError, s)
+}
+
+// PostEmbed posts text to the channel as an embed in the given color
+func PostEmbed(channel string, text string, color int, s *discordgo.Session) error {
+	_, err := s.ChannelMessageSendEmbed(channel, &discordgo.MessageEmbed{Description: text, Color: color})
+	if err != nil {
+		return fmt.Errorf("failed to post message: %w", err)
+	}
+	return nil
+}
+
+// HandleInteraction handles the buttons posted with notifications, their custom id is the models.Action* id and
+// the txid it acts on separated by a ":"
+func HandleInteraction(i *discordgo.InteractionCreate, s *discordgo.Session, watchTransaction chan models.WatchTx, store *utils.WatchStore) error {
+	if i.Type != discordgo.InteractionMessageComponent {
+		return nil
+	}
+	//acknowledge the click, the outcome is posted to the channel like the mention replies
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredMessageUpdate})
+	if err != nil {
+		return fmt.Errorf("failed to acknowledge interaction: %w", err)
+	}
+	action, value, _ := strings.Cut(i.MessageComponentData().CustomID, ":")
+	switch action {
+	case models.ActionMoveWatch:
+		//custom ids are capped at 100 characters, too short for both txids, so the watch is found by its replacement
+		for _, watchTx := range utils.WatchesForChannel(store, models.FrontendDiscord, i.ChannelID) {
+			if watchTx.ReplacedBy == value {
+				return MoveWatch(i.ChannelID, watchTx.TxID, value, s, watchTransaction, store)
+			}
+		}
+		return PostError(i.ChannelID, fmt.Sprintf("The transaction replaced by %s is no longer being watched in this channel", value), s)
+	case models.ActionStopWatch:
+		return HandleUnwatch(i.ChannelID, value, s, store)
+	}
+	return nil
+}
+
+// MoveWatch replaces the channel's watch on oldTxId with one on newTxId, keeping the confirmation target
+func MoveWatch(channel string, oldTxId string, newTxId string, s *discordgo.Session, watchTransaction chan models.WatchTx, store *utils.WatchStore) error {
+	if !utils.MoveWatches(store, models.FrontendDiscord, channel, oldTxId, newTxId, watchTransaction) {
+		return PostError(channel, fmt.Sprintf("The transaction %s is no longer being watched in this channel", oldTxId), s)
+	}
+	return PostEmbed(channel, fmt.Sprintf("Your watch has moved from %s to the replacement %s", oldTxId, newTxId), ColorOk, s)
+}
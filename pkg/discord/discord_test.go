@@ -0,0 +1,106 @@
+package discord
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tx-tracker/pkg/models"
+	"tx-tracker/pkg/utils"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// stubAPI answers the channel message endpoint of the REST api, keeping every embed posted
+type stubAPI struct {
+	t      *testing.T
+	mutex  sync.Mutex
+	posted []discordgo.MessageEmbed
+}
+
+func newStubAPI(t *testing.T) (*discordgo.Session, *stubAPI) {
+	stub := &stubAPI{t: t}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	endpoint := discordgo.EndpointChannels
+	discordgo.EndpointChannels = server.URL + "/channels/"
+	t.Cleanup(func() { discordgo.EndpointChannels = endpoint })
+	session, err := discordgo.New("Bot token")
+	if err != nil {
+		t.Fatalf("failed to create the session: %s", err)
+	}
+	return session, stub
+}
+
+func (s *stubAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/channels/C1/messages" {
+		s.t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bot token" {
+		s.t.Errorf("request without the bot token")
+	}
+	message := discordgo.MessageSend{}
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		s.t.Fatalf("failed to decode the message: %s", err)
+	}
+	s.mutex.Lock()
+	for _, embed := range message.Embeds {
+		s.posted = append(s.posted, *embed)
+	}
+	s.mutex.Unlock()
+	json.NewEncoder(w).Encode(discordgo.Message{ID: "M1", ChannelID: "C1"})
+}
+
+func (s *stubAPI) last() discordgo.MessageEmbed {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.posted) == 0 {
+		s.t.Fatalf("nothing was posted")
+	}
+	return s.posted[len(s.posted)-1]
+}
+
+func TestHandleMentionToBot(t *testing.T) {
+	session, stub := newStubAPI(t)
+	store := utils.NewWatchStore()
+	store.Add(models.WatchTx{TxID: "aa", Channel: "C1", Frontend: models.FrontendDiscord, Confs: 6, ConfsCount: 1, TimeRequested: time.Now().UTC().Unix()})
+	watchTransaction := make(chan models.WatchTx, 1)
+
+	tests := []struct {
+		content   string
+		wantText  string
+		wantColor int
+	}{
+		{content: "<@B1> list", wantText: "Watching 1 transactions in this channel:", wantColor: ColorOk},
+		{content: "<@B1> update txId: aa confirms: 3", wantText: utils.UpdatedText("aa", 3, 1), wantColor: ColorOk},
+		{content: "<@B1> update txId: bb confirms: 3", wantText: utils.NotWatchedText(models.FrontendDiscord, "bb"), wantColor: ColorError},
+		{content: "<@B1> unwatch txId: aa", wantText: utils.UnwatchedText("aa"), wantColor: ColorOk},
+		{content: "<@B1> list", wantText: "Nothing is being watched in this channel", wantColor: ColorOk},
+		{content: "<@B1> txId: cc confirms: 0", wantText: "Failed to setup watcher", wantColor: ColorError},
+	}
+	for _, test := range tests {
+		message := &discordgo.Message{ChannelID: "C1", Content: test.content}
+		if err := HandleMentionToBot(message, session, watchTransaction, store, utils.NotifyPolicy{}); err != nil {
+			t.Fatalf("%q failed: %s", test.content, err)
+		}
+		embed := stub.last()
+		if !strings.HasPrefix(embed.Description, test.wantText) || embed.Color != test.wantColor {
+			t.Errorf("%q got %q in %x, want %q in %x", test.content, embed.Description, embed.Color, test.wantText, test.wantColor)
+		}
+	}
+
+	message := &discordgo.Message{ChannelID: "C1", Content: "<@B1> txId: cc confirms: 2"}
+	if err := HandleMentionToBot(message, session, watchTransaction, store, utils.NotifyPolicy{}); err != nil {
+		t.Fatalf("watching failed: %s", err)
+	}
+	watchTx := <-watchTransaction
+	if watchTx.TxID != "cc" || watchTx.Confs != 2 || watchTx.Channel != "C1" || watchTx.Frontend != models.FrontendDiscord {
+		t.Errorf("got %+v, want the watch on cc from the discord channel", watchTx)
+	}
+}
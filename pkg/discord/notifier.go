@@ -0,0 +1,83 @@
+package discord
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"tx-tracker/pkg/mempool"
+	"tx-tracker/pkg/models"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Notifier posts the messages about watches requested from discord as embeds in their channel
+type Notifier struct {
+	Session *discordgo.Session
+}
+
+func NewNotifier(session *discordgo.Session) *Notifier {
+	return &Notifier{Session: session}
+}
+
+func (n *Notifier) send(watchTx models.WatchTx, message *discordgo.MessageSend) {
+	_, err := n.Session.ChannelMessageSendComplex(watchTx.Channel, message)
+	if err != nil {
+		log.Printf("failed to post discord message: %s", err.Error())
+	}
+}
+
+func embed(text string, color int) []*discordgo.MessageEmbed {
+	return []*discordgo.MessageEmbed{{Description: text, Color: color}}
+}
+
+// button lays out a single button, its custom id is the action and the txid it acts on
+func button(action string, value string, label string, style discordgo.ButtonStyle) []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+		discordgo.Button{Label: label, Style: style, CustomID: fmt.Sprintf("%s:%s", action, value)},
+	}}}
+}
+
+func stopButton(watchTx models.WatchTx) []discordgo.MessageComponent {
+	return button(models.ActionStopWatch, watchTx.TxID, "Stop watching", discordgo.SecondaryButton)
+}
+
+func (n *Notifier) SendErrorMessage(watchTx models.WatchTx, text string) {
+	n.send(watchTx, &discordgo.MessageSend{Embeds: embed(mempool.ErrorText(text), ColorError)})
+}
+
+func (n *Notifier) SendMempoolMessage(watchTx models.WatchTx) {
+	n.send(watchTx, &discordgo.MessageSend{Embeds: embed(mempool.MempoolText(watchTx), ColorOk)})
+}
+
+func (n *Notifier) SendDroppedMessage(watchTx models.WatchTx) {
+	n.send(watchTx, &discordgo.MessageSend{Embeds: embed(mempool.DroppedText(watchTx), ColorError)})
+}
+
+func (n *Notifier) SendReplacedMessage(watchTx models.WatchTx) {
+	n.send(watchTx, &discordgo.MessageSend{
+		Embeds:     embed(mempool.ReplacedText(watchTx), ColorError),
+		Components: button(models.ActionMoveWatch, watchTx.ReplacedBy, "Watch the replacement instead", discordgo.PrimaryButton),
+	})
+}
+
+func (n *Notifier) SendReorgMessage(watchTx models.WatchTx, lostConfs int) {
+	//bots have to send emoji as unicode and @here only pings from the message content
+	text := strings.Replace(mempool.ReorgText(watchTx, lostConfs), ":rotating_light:", "\U0001F6A8", 1)
+	n.send(watchTx, &discordgo.MessageSend{
+		Content:         "@here",
+		Embeds:          embed(text, ColorError),
+		AllowedMentions: &discordgo.MessageAllowedMentions{Parse: []discordgo.AllowedMentionType{discordgo.AllowedMentionTypeEveryone}},
+	})
+}
+
+func (n *Notifier) SendFirstConfMessage(watchTx models.WatchTx, confirmed models.ConfirmedPayload) {
+	n.send(watchTx, &discordgo.MessageSend{Embeds: embed(mempool.FirstConfText(watchTx, confirmed), ColorOk), Components: stopButton(watchTx)})
+}
+
+func (n *Notifier) SendUpdatedConfMessage(watchTx models.WatchTx) {
+	n.send(watchTx, &discordgo.MessageSend{Embeds: embed(mempool.UpdatedConfText(watchTx), ColorOk), Components: stopButton(watchTx)})
+}
+
+func (n *Notifier) SendFinalMessage(watchTx models.WatchTx) {
+	n.send(watchTx, &discordgo.MessageSend{Embeds: embed(mempool.FinalText(watchTx), ColorOk)})
+}
@@ -0,0 +1,165 @@
+package electrum
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	bech32Charset   = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	base58Alphabet  = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	bech32Constant  = 1
+	bech32mConstant = 0x2bc830a3
+	opDup           = 0x76
+	opHash160       = 0xa9
+	opEqual         = 0x87
+	opEqualVerify   = 0x88
+	opCheckSig      = 0xac
+	opOne           = 0x51
+)
+
+var errInvalidAddress = errors.New("invalid bitcoin address")
+
+// AddressToScript returns the output script an address pays to, electrum servers index history by its hash
+func AddressToScript(address string) ([]byte, error) {
+	lower := strings.ToLower(address)
+	for _, hrp := range []string{"bc1", "tb1", "bcrt1"} {
+		if strings.HasPrefix(lower, hrp) {
+			return segwitScript(address)
+		}
+	}
+	return base58Script(address)
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generator := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func segwitScript(address string) ([]byte, error) {
+	if strings.ToLower(address) != address && strings.ToUpper(address) != address {
+		return nil, errInvalidAddress
+	}
+	address = strings.ToLower(address)
+	separator := strings.LastIndex(address, "1")
+	if separator < 1 || separator+7 > len(address) {
+		return nil, errInvalidAddress
+	}
+	hrp := address[:separator]
+	data := []byte{}
+	for _, c := range address[separator+1:] {
+		index := strings.IndexRune(bech32Charset, c)
+		if index < 0 {
+			return nil, errInvalidAddress
+		}
+		data = append(data, byte(index))
+	}
+	expanded := []byte{}
+	for _, c := range hrp {
+		expanded = append(expanded, byte(c>>5))
+	}
+	expanded = append(expanded, 0)
+	for _, c := range hrp {
+		expanded = append(expanded, byte(c&31))
+	}
+	checksum := bech32Polymod(append(expanded, data...))
+	data = data[:len(data)-6]
+	if len(data) == 0 {
+		return nil, errInvalidAddress
+	}
+	version := data[0]
+	if (version == 0 && checksum != bech32Constant) || (version != 0 && checksum != bech32mConstant) {
+		return nil, fmt.Errorf("%w: bad checksum", errInvalidAddress)
+	}
+	program, err := convertBits(data[1:], 5, 8)
+	if err != nil {
+		return nil, err
+	}
+	if len(program) < 2 || len(program) > 40 || version > 16 {
+		return nil, errInvalidAddress
+	}
+	//version 0 programs are only ever a 20 byte key hash or a 32 byte script hash
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		return nil, fmt.Errorf("%w: bad version 0 program length", errInvalidAddress)
+	}
+	script := []byte{0}
+	if version > 0 {
+		script[0] = opOne + version - 1
+	}
+	script = append(script, byte(len(program)))
+	return append(script, program...), nil
+}
+
+func convertBits(data []byte, from uint, to uint) ([]byte, error) {
+	acc := uint(0)
+	bits := uint(0)
+	out := []byte{}
+	maxValue := uint(1<<to) - 1
+	for _, value := range data {
+		acc = acc<<from | uint(value)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte((acc>>bits)&maxValue))
+		}
+	}
+	if bits >= from || (acc<<(to-bits))&maxValue != 0 {
+		return nil, fmt.Errorf("%w: bad padding", errInvalidAddress)
+	}
+	return out, nil
+}
+
+func base58Script(address string) ([]byte, error) {
+	value := big.NewInt(0)
+	base := big.NewInt(58)
+	for _, c := range address {
+		index := strings.IndexRune(base58Alphabet, c)
+		if index < 0 {
+			return nil, errInvalidAddress
+		}
+		value.Mul(value, base)
+		value.Add(value, big.NewInt(int64(index)))
+	}
+	decoded := value.Bytes()
+	for _, c := range address {
+		if c != '1' {
+			break
+		}
+		decoded = append([]byte{0}, decoded...)
+	}
+	if len(decoded) != 25 {
+		return nil, errInvalidAddress
+	}
+	first := sha256.Sum256(decoded[:21])
+	second := sha256.Sum256(first[:])
+	if !bytes.Equal(second[:4], decoded[21:]) {
+		return nil, fmt.Errorf("%w: bad checksum", errInvalidAddress)
+	}
+	hash := decoded[1:21]
+	switch decoded[0] {
+	case 0x00, 0x6f:
+		script := []byte{opDup, opHash160, 20}
+		script = append(script, hash...)
+		return append(script, opEqualVerify, opCheckSig), nil
+	case 0x05, 0xc4:
+		script := []byte{opHash160, 20}
+		script = append(script, hash...)
+		return append(script, opEqual), nil
+	default:
+		return nil, fmt.Errorf("%w: unknown version %d", errInvalidAddress, decoded[0])
+	}
+}
@@ -0,0 +1,71 @@
+package electrum
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestAddressToScript(t *testing.T) {
+	tests := []struct {
+		address string
+		script  string
+	}{
+		//BIP173
+		{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{"tb1qqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesrxh6hy", "0020000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+		//BIP350
+		{"bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y", "5128751e76e8199196d454941c45d1b3a323f1433bd6751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"BC1SW50QGDZ25J", "6002751e"},
+		{"bc1zw508d6qejxtdg4y5r3zarvaryvaxxpcs", "5210751e76e8199196d454941c45d1b3a323"},
+		{"tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c", "5120000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+		//base58
+		{"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", "76a91477bff20c60e522dfaa3350c39b030a5d004e839a88ac"},
+		{"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", "a914b472a266d0bd89c13706a4132ccfb16f7c3b9fcb87"},
+	}
+	for _, test := range tests {
+		script, err := AddressToScript(test.address)
+		if err != nil {
+			t.Errorf("%s: %s", test.address, err)
+			continue
+		}
+		if hex.EncodeToString(script) != test.script {
+			t.Errorf("%s: got script %x, want %s", test.address, script, test.script)
+		}
+	}
+}
+
+func TestAddressToScriptInvalid(t *testing.T) {
+	tests := []struct {
+		address string
+		reason  string
+	}{
+		{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5", "bad bech32 checksum"},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd", "bech32 checksum on a v1 program"},
+		{"tb1z0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqglt7rf", "bech32 checksum on a v2 program"},
+		{"BC1S0XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ54WELL", "bech32 checksum on a v16 program"},
+		{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh", "bech32m checksum on a v0 program"},
+		{"tb1q0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq24jc47", "bech32m checksum on a v0 program"},
+		{"bc1p38j9r5y49hruaue7wxjce0updqjuyyx0kh56v8s25huc6995vvpql3jow4", "invalid character"},
+		{"BC130XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ7ZWS8R", "witness version 17"},
+		{"bc1pw5dgrnzv", "1 byte program"},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7v8n0nx0muaewav253zgeav", "41 byte program"},
+		{"BC1QR508D6QEJXTDG4Y5R3ZARVARYV98GJ9P", "16 byte v0 program"},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sL5k7", "mixed case"},
+		{"bc1gmk9yu", "empty data"},
+		{"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN3", "bad base58 checksum"},
+		{"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN0", "invalid base58 character"},
+	}
+	for _, test := range tests {
+		script, err := AddressToScript(test.address)
+		if err == nil {
+			t.Errorf("%s (%s): got script %x, want an error", test.address, test.reason, script)
+			continue
+		}
+		if !errors.Is(err, errInvalidAddress) {
+			t.Errorf("%s (%s): got %s, want errInvalidAddress", test.address, test.reason, err)
+		}
+	}
+}
@@ -0,0 +1,519 @@
+package electrum
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"tx-tracker/pkg/models"
+	"tx-tracker/pkg/utils"
+)
+
+const protocolVersion = "1.4"
+
+var errClosed = errors.New("electrum connection closed")
+
+// Server holds the connection settings for the electrum server serving a single network
+type Server struct {
+	Address string
+	UseTLS  bool
+	// SkipVerify accepts self-signed certificates, which most electrs/Fulcrum installs use
+	SkipVerify bool
+}
+
+// Electrum is the ChainBackend backed by an Electrum protocol server (electrs, Fulcrum, ElectrumX)
+type Electrum struct {
+	servers map[string]Server
+	clients map[string]*client
+	mutex   sync.Mutex
+}
+
+type HeaderNotification struct {
+	Height int    `json:"height"`
+	Hex    string `json:"hex"`
+}
+
+type HistoryItem struct {
+	TxHash string `json:"tx_hash"`
+	Height int    `json:"height"`
+}
+
+type MerkleProof struct {
+	BlockHeight int      `json:"block_height"`
+	Merkle      []string `json:"merkle"`
+	Pos         int      `json:"pos"`
+}
+
+type request struct {
+	JsonRPC string        `json:"jsonrpc"`
+	Id      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type response struct {
+	Id     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("electrum error %d: %s", e.Code, e.Message)
+}
+
+// client is a single connection to an electrum server, requests are matched to responses by id and
+// header notifications are handed to whoever is listening for blocks
+type client struct {
+	conn       net.Conn
+	writeMutex sync.Mutex
+	mutex      sync.Mutex
+	pending    map[int]chan response
+	nextId     int
+	headers    chan HeaderNotification
+	done       chan struct{}
+}
+
+func NewElectrum(servers map[string]Server) *Electrum {
+	return &Electrum{
+		servers: servers,
+		clients: make(map[string]*client),
+	}
+}
+
+func dial(server Server) (*client, error) {
+	dialer := &net.Dialer{Timeout: time.Second * 15, KeepAlive: time.Second * 30}
+	var conn net.Conn
+	var err error
+	if server.UseTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", server.Address, &tls.Config{InsecureSkipVerify: server.SkipVerify})
+	} else {
+		conn, err = dialer.Dial("tcp", server.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &client{
+		conn:    conn,
+		pending: make(map[int]chan response),
+		headers: make(chan HeaderNotification, 16),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+
+	var version []string
+	errVersion := c.call("server.version", []interface{}{"tx-tracker", protocolVersion}, &version)
+	if errVersion != nil {
+		c.conn.Close()
+		return nil, errVersion
+	}
+	log.Printf("connected to electrum server %s %v", server.Address, version)
+	return c, nil
+}
+
+func (c *client) readLoop() {
+	defer close(c.done)
+	defer c.conn.Close()
+	reader := bufio.NewReader(c.conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			log.Printf("error while reading from electrum server: %s", err.Error())
+			return
+		}
+		resp := response{}
+		errMarshal := json.Unmarshal(line, &resp)
+		if errMarshal != nil {
+			log.Printf("\nerror while unmarshalling electrum message %s", errMarshal.Error())
+			continue
+		}
+		if resp.Id == nil {
+			c.handleNotification(resp)
+			continue
+		}
+		c.mutex.Lock()
+		waiting, ok := c.pending[*resp.Id]
+		delete(c.pending, *resp.Id)
+		c.mutex.Unlock()
+		if ok {
+			waiting <- resp
+		}
+	}
+}
+
+func (c *client) handleNotification(resp response) {
+	if resp.Method != "blockchain.headers.subscribe" {
+		return
+	}
+	var headers []HeaderNotification
+	err := json.Unmarshal(resp.Params, &headers)
+	if err != nil {
+		log.Printf("\nerror while unmarshalling electrum header notification %s", err.Error())
+		return
+	}
+	for _, header := range headers {
+		select {
+		case c.headers <- header:
+		default:
+			log.Printf("dropping electrum header notification for height %d, nobody is listening", header.Height)
+		}
+	}
+}
+
+func (c *client) call(method string, params []interface{}, result interface{}) error {
+	waiting := make(chan response, 1)
+	c.mutex.Lock()
+	c.nextId++
+	id := c.nextId
+	c.pending[id] = waiting
+	c.mutex.Unlock()
+
+	raw, err := json.Marshal(request{JsonRPC: "2.0", Id: id, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	c.writeMutex.Lock()
+	_, errWrite := c.conn.Write(append(raw, '\n'))
+	c.writeMutex.Unlock()
+	if errWrite != nil {
+		return errWrite
+	}
+
+	select {
+	case resp := <-waiting:
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(resp.Result, result)
+	case <-c.done:
+		return errClosed
+	case <-time.After(time.Second * 30):
+		c.mutex.Lock()
+		delete(c.pending, id)
+		c.mutex.Unlock()
+		return fmt.Errorf("electrum %s timed out", method)
+	}
+}
+
+func (c *client) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// getClient returns the open connection for the network, dialing a new one when there is none
+func (e *Electrum) getClient(network string) (*client, error) {
+	//watches on mainnet are stored with an empty network
+	if network == "" {
+		network = "mainnet"
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	c, ok := e.clients[network]
+	if ok && !c.closed() {
+		return c, nil
+	}
+	server, ok := e.servers[network]
+	if !ok {
+		return nil, fmt.Errorf("no electrum server configured for network %s", network)
+	}
+	c, err := dial(server)
+	if err != nil {
+		return nil, err
+	}
+	e.clients[network] = c
+	return c, nil
+}
+
+func (e *Electrum) call(network string, method string, params []interface{}, result interface{}) error {
+	c, err := e.getClient(network)
+	if err != nil {
+		return err
+	}
+	return c.call(method, params, result)
+}
+
+func (e *Electrum) GetLastBlockHeight(network string) (*int, error) {
+	tip := HeaderNotification{}
+	err := e.call(network, "blockchain.headers.subscribe", []interface{}{}, &tip)
+	if err != nil {
+		log.Printf("failed to get tip from electrum server: %s", err.Error())
+		return nil, err
+	}
+	log.Printf("\nLast Block Height %d", tip.Height)
+	return &tip.Height, nil
+}
+
+func (e *Electrum) CheckTransactionWasConfirmed(txId string, network string) (*models.ConfirmedPayload, error) {
+	tx, err := e.getTransaction(txId, network)
+	if err != nil {
+		return nil, err
+	}
+	script, ok := tx.IndexedScript()
+	if !ok {
+		return nil, fmt.Errorf("transaction %s has no spendable outputs", txId)
+	}
+	//electrum indexes by script, so look the tx up in the history of its first spendable output
+	var history []HistoryItem
+	errHistory := e.call(network, "blockchain.scripthash.get_history", []interface{}{ScriptHash(script)}, &history)
+	if errHistory != nil {
+		return nil, errHistory
+	}
+	height := 0
+	for _, item := range history {
+		if item.TxHash == txId {
+			height = item.Height
+		}
+	}
+	//0 and -1 are used for transactions still in the mempool
+	if height <= 0 {
+		return &models.ConfirmedPayload{Confirmed: false}, nil
+	}
+	proof := MerkleProof{}
+	errMerkle := e.call(network, "blockchain.transaction.get_merkle", []interface{}{txId, height}, &proof)
+	if errMerkle != nil {
+		return nil, errMerkle
+	}
+	blockHash, blockTime, errHeader := e.getBlockHeader(proof.BlockHeight, network)
+	if errHeader != nil {
+		return nil, errHeader
+	}
+	return &models.ConfirmedPayload{
+		Confirmed:   true,
+		BlockHeight: &proof.BlockHeight,
+		BlockHash:   &blockHash,
+		BlockTime:   &blockTime,
+	}, nil
+}
+
+func (e *Electrum) GetTransaction(txId string, network string) (*models.TxInfo, error) {
+	tx, err := e.getTransaction(txId, network)
+	if isNotFound(err) {
+		return nil, models.ErrTxNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	status, err := e.CheckTransactionWasConfirmed(txId, network)
+	if err != nil {
+		return nil, err
+	}
+	info := &models.TxInfo{TxID: txId, VSize: tx.VSize(), Confirmed: status.Confirmed}
+	for _, in := range tx.Inputs {
+		info.Inputs = append(info.Inputs, models.Outpoint{TxID: in.PrevTxId, Vout: int(in.Vout)})
+	}
+	if info.Confirmed {
+		return info, nil
+	}
+	//electrum has no fee lookup, it is the value of the spent outputs less the value created
+	var inputValue int64
+	for _, in := range tx.Inputs {
+		prevTx, err := e.getTransaction(in.PrevTxId, network)
+		if err != nil {
+			return nil, err
+		}
+		if int(in.Vout) >= len(prevTx.Outputs) {
+			return nil, fmt.Errorf("input %s:%d of %s does not exist", in.PrevTxId, in.Vout, txId)
+		}
+		inputValue += prevTx.Outputs[in.Vout].Value
+	}
+	var outputValue int64
+	for _, out := range tx.Outputs {
+		outputValue += out.Value
+	}
+	info.Fee = inputValue - outputValue
+	return info, nil
+}
+
+// GetReplacement looks through the history of the script each input paid for another transaction spending it
+func (e *Electrum) GetReplacement(txId string, network string, inputs []models.Outpoint) (string, error) {
+	for _, input := range inputs {
+		prevTx, err := e.getTransaction(input.TxID, network)
+		if err != nil {
+			return "", err
+		}
+		if input.Vout >= len(prevTx.Outputs) {
+			continue
+		}
+		var history []HistoryItem
+		errHistory := e.call(network, "blockchain.scripthash.get_history", []interface{}{ScriptHash(prevTx.Outputs[input.Vout].Script)}, &history)
+		if errHistory != nil {
+			return "", errHistory
+		}
+		for _, item := range history {
+			if item.TxHash == txId || item.TxHash == input.TxID {
+				continue
+			}
+			spender, err := e.getTransaction(item.TxHash, network)
+			if err != nil {
+				return "", err
+			}
+			for _, in := range spender.Inputs {
+				if in.PrevTxId == input.TxID && int(in.Vout) == input.Vout {
+					return item.TxHash, nil
+				}
+			}
+		}
+	}
+	return "", nil
+}
+
+// notFoundMessages are how electrs, Fulcrum and ElectrumX report an unknown transaction, they pass on bitcoind's
+// message but differ in the error code they use
+var notFoundMessages = []string{"no such mempool or blockchain transaction", "transaction not found", "missing transaction"}
+
+// isNotFound is true for the server saying the transaction doesn't exist, not for it failing to look it up
+func isNotFound(err error) bool {
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	message := strings.ToLower(rpcErr.Message)
+	for _, notFound := range notFoundMessages {
+		if strings.Contains(message, notFound) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Electrum) getTransaction(txId string, network string) (*Tx, error) {
+	var rawTx string
+	err := e.call(network, "blockchain.transaction.get", []interface{}{txId, false}, &rawTx)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTx(rawTx)
+}
+
+// getBlockHeader returns the hash and timestamp of the block at height
+func (e *Electrum) getBlockHeader(height int, network string) (string, int, error) {
+	var headerHex string
+	err := e.call(network, "blockchain.block.header", []interface{}{height}, &headerHex)
+	if err != nil {
+		return "", 0, err
+	}
+	header, err := hex.DecodeString(headerHex)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(header) != 80 {
+		return "", 0, fmt.Errorf("block header at %d is %d bytes", height, len(header))
+	}
+	return utils.HashBlockHeader(header), int(binary.LittleEndian.Uint32(header[68:72])), nil
+}
+
+// parseHeaderHashes returns the hash of a hex serialized block header and the hash of the block before it
+func parseHeaderHashes(headerHex string) (string, string, error) {
+	header, err := hex.DecodeString(headerHex)
+	if err != nil {
+		return "", "", err
+	}
+	if len(header) != 80 {
+		return "", "", fmt.Errorf("block header is %d bytes", len(header))
+	}
+	return utils.HashBlockHeader(header), reverseHex(header[4:36]), nil
+}
+
+func (e *Electrum) ListenForBlocks(newBlock chan models.NewBlock, network string, ctx context.Context) {
+	eventNetwork := network
+	if eventNetwork == "mainnet" {
+		eventNetwork = ""
+	}
+	for {
+		err := e.subscribeHeaders(newBlock, network, eventNetwork, ctx)
+		select {
+		case <-ctx.Done():
+			log.Printf("Shutting down electrum header listener for %s", network)
+			return
+		case <-time.After(time.Second * 5):
+			log.Printf("electrum header subscription lost (%v), re-creating and continuing to listen", err)
+		}
+	}
+}
+
+// subscribeHeaders forwards every new tip from the server until the connection drops
+func (e *Electrum) subscribeHeaders(newBlock chan models.NewBlock, network string, eventNetwork string, ctx context.Context) error {
+	c, err := e.getClient(network)
+	if err != nil {
+		return err
+	}
+	tip := HeaderNotification{}
+	errSub := c.call("blockchain.headers.subscribe", []interface{}{}, &tip)
+	if errSub != nil {
+		return errSub
+	}
+	log.Printf("listening to the blocks event from electrum server, current tip %d", tip.Height)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.done:
+			return errClosed
+		case header := <-c.headers:
+			log.Printf("\nnew block at height %d", header.Height)
+			blockHash, previousHash, err := parseHeaderHashes(header.Hex)
+			if err != nil {
+				//without the hashes the block would look like a reorg, the next header catches the watches up
+				log.Printf("\nerror while parsing electrum header %s", err.Error())
+				continue
+			}
+			newBlock <- models.NewBlock{IsNew: true, Network: eventNetwork, BlockHeight: header.Height, BlockHash: blockHash, PreviousBlockHash: previousHash}
+		}
+	}
+}
+
+func (e *Electrum) GetAddressTransactions(address string, network string) ([]models.AddressTx, error) {
+	script, err := AddressToScript(address)
+	if err != nil {
+		return nil, err
+	}
+	var history []HistoryItem
+	errHistory := e.call(network, "blockchain.scripthash.get_history", []interface{}{ScriptHash(script)}, &history)
+	if errHistory != nil {
+		return nil, errHistory
+	}
+	addressTxs := []models.AddressTx{}
+	for _, item := range history {
+		tx, err := e.getTransaction(item.TxHash, network)
+		if err != nil {
+			return nil, err
+		}
+		addressTx := models.AddressTx{TxID: item.TxHash, Confirmed: item.Height > 0}
+		if item.Height > 0 {
+			addressTx.BlockHeight = item.Height
+		}
+		for _, out := range tx.Outputs {
+			if bytes.Equal(out.Script, script) {
+				addressTx.Amount += out.Value
+			}
+		}
+		//txs only spending from the address are in the history too
+		if addressTx.Amount > 0 {
+			addressTxs = append(addressTxs, addressTx)
+		}
+	}
+	return addressTxs, nil
+}
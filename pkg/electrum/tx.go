@@ -0,0 +1,194 @@
+package electrum
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+var errShortTx = errors.New("raw transaction ended early")
+
+const opReturn = 0x6a
+
+type TxInput struct {
+	PrevTxId string
+	Vout     uint32
+}
+
+type TxOutput struct {
+	Value  int64
+	Script []byte
+}
+
+// Tx is the part of a serialized bitcoin transaction the tracker needs
+type Tx struct {
+	Inputs  []TxInput
+	Outputs []TxOutput
+	// Weight in weight units, vsize is Weight/4 rounded up
+	Weight int
+}
+
+func (t *Tx) VSize() int {
+	return (t.Weight + 3) / 4
+}
+
+// IndexedScript returns the script of the first output electrum servers keep a history for, OP_RETURN outputs
+// can never be spent so aren't indexed
+func (t *Tx) IndexedScript() ([]byte, bool) {
+	for _, out := range t.Outputs {
+		if len(out.Script) > 0 && out.Script[0] != opReturn {
+			return out.Script, true
+		}
+	}
+	return nil, false
+}
+
+type txReader struct {
+	raw []byte
+	pos int
+}
+
+func (r *txReader) read(n int) ([]byte, error) {
+	if r.pos+n > len(r.raw) {
+		return nil, errShortTx
+	}
+	out := r.raw[r.pos : r.pos+n]
+	r.pos += n
+	return out, nil
+}
+
+func (r *txReader) readVarInt() (uint64, error) {
+	prefix, err := r.read(1)
+	if err != nil {
+		return 0, err
+	}
+	switch prefix[0] {
+	case 0xfd:
+		b, err := r.read(2)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.LittleEndian.Uint16(b)), nil
+	case 0xfe:
+		b, err := r.read(4)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.LittleEndian.Uint32(b)), nil
+	case 0xff:
+		b, err := r.read(8)
+		if err != nil {
+			return 0, err
+		}
+		return binary.LittleEndian.Uint64(b), nil
+	default:
+		return uint64(prefix[0]), nil
+	}
+}
+
+func (r *txReader) readVarBytes() ([]byte, error) {
+	size, err := r.readVarInt()
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(len(r.raw)) {
+		return nil, errShortTx
+	}
+	return r.read(int(size))
+}
+
+// ParseTx decodes a hex serialized transaction, with or without witness data
+func ParseTx(rawHex string) (*Tx, error) {
+	raw, err := hex.DecodeString(rawHex)
+	if err != nil {
+		return nil, err
+	}
+	r := &txReader{raw: raw}
+	if _, err := r.read(4); err != nil {
+		return nil, err
+	}
+	segwit := len(raw) > 6 && raw[4] == 0x00 && raw[5] == 0x01
+	if segwit {
+		r.pos += 2
+	}
+	inputCount, err := r.readVarInt()
+	if err != nil {
+		return nil, err
+	}
+	tx := &Tx{}
+	for i := uint64(0); i < inputCount; i++ {
+		prevHash, err := r.read(32)
+		if err != nil {
+			return nil, err
+		}
+		vout, err := r.read(4)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := r.readVarBytes(); err != nil {
+			return nil, err
+		}
+		if _, err := r.read(4); err != nil {
+			return nil, err
+		}
+		tx.Inputs = append(tx.Inputs, TxInput{PrevTxId: reverseHex(prevHash), Vout: binary.LittleEndian.Uint32(vout)})
+	}
+	outputCount, err := r.readVarInt()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < outputCount; i++ {
+		value, err := r.read(8)
+		if err != nil {
+			return nil, err
+		}
+		script, err := r.readVarBytes()
+		if err != nil {
+			return nil, err
+		}
+		tx.Outputs = append(tx.Outputs, TxOutput{Value: int64(binary.LittleEndian.Uint64(value)), Script: script})
+	}
+	witnessStart := r.pos
+	if segwit {
+		for i := uint64(0); i < inputCount; i++ {
+			items, err := r.readVarInt()
+			if err != nil {
+				return nil, err
+			}
+			for j := uint64(0); j < items; j++ {
+				if _, err := r.readVarBytes(); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if _, err := r.read(4); err != nil {
+		return nil, err
+	}
+	if r.pos != len(raw) {
+		return nil, fmt.Errorf("raw transaction has %d trailing bytes", len(raw)-r.pos)
+	}
+	witnessSize := 0
+	if segwit {
+		witnessSize = 2 + r.pos - 4 - witnessStart
+	}
+	baseSize := len(raw) - witnessSize
+	tx.Weight = baseSize*3 + len(raw)
+	return tx, nil
+}
+
+// ScriptHash returns the electrum scripthash of an output script, the reversed sha256 of the script in hex
+func ScriptHash(script []byte) string {
+	hash := sha256.Sum256(script)
+	return reverseHex(hash[:])
+}
+
+func reverseHex(b []byte) string {
+	reversed := make([]byte, len(b))
+	for i := range b {
+		reversed[len(b)-1-i] = b[i]
+	}
+	return hex.EncodeToString(reversed)
+}
@@ -0,0 +1,106 @@
+package electrum
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// pieces of the test transactions, sizes in bytes are in the comments
+const (
+	txVersion  = "02000000" // 4
+	txLocktime = "00000000" // 4
+	// 41 with an empty scriptSig
+	txInput = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa" + "01000000" + "00" + "fdffffff"
+	// 31, 100000 sats to a P2WPKH
+	txOutput = "a086010000000000" + "16" + "0014bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	// 10, 0 sats to OP_RETURN
+	txOpReturn = "0000000000000000" + "01" + "6a"
+)
+
+// txWitness is 107 bytes, a signature and a compressed public key
+var txWitness = "02" + "47" + strings.Repeat("dd", 71) + "21" + strings.Repeat("ee", 33)
+
+// legacyInput spends a P2PKH, its 106 byte scriptSig makes it 147 bytes
+var legacyInput = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa" + "01000000" + "6a" + strings.Repeat("cc", 106) + "ffffffff"
+
+func TestParseTxWeight(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		inputs  int
+		outputs int
+		weight  int
+		vsize   int
+	}{
+		{
+			//188 bytes all counted 4 times
+			name: "legacy", raw: txVersion + "01" + legacyInput + "01" + txOutput + txLocktime,
+			inputs: 1, outputs: 1, weight: 752, vsize: 188,
+		},
+		{
+			//82 bytes without the witness, 191 with the marker, flag & witness: 82*3 + 191
+			name: "segwit", raw: txVersion + "0001" + "01" + txInput + "01" + txOutput + txWitness + txLocktime,
+			inputs: 1, outputs: 1, weight: 437, vsize: 110,
+		},
+		{
+			//123 bytes without the witness, the second input has an empty one: 123*3 + 233, vsize rounds up
+			name: "segwit with an empty witness", raw: txVersion + "0001" + "02" + txInput + txInput + "01" + txOutput + txWitness + "00" + txLocktime,
+			inputs: 2, outputs: 1, weight: 602, vsize: 151,
+		},
+		{
+			//239 bytes without the witness, the legacy input's empty witness & the segwit one's: 239*3 + 349
+			name: "segwit spending a legacy input", raw: txVersion + "0001" + "02" + legacyInput + txInput + "02" + txOpReturn + txOutput + "00" + txWitness + txLocktime,
+			inputs: 2, outputs: 2, weight: 1066, vsize: 267,
+		},
+	}
+	for _, test := range tests {
+		tx, err := ParseTx(test.raw)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if len(tx.Inputs) != test.inputs || len(tx.Outputs) != test.outputs {
+			t.Errorf("%s: got %d inputs & %d outputs, want %d & %d", test.name, len(tx.Inputs), len(tx.Outputs), test.inputs, test.outputs)
+		}
+		if tx.Weight != test.weight || tx.VSize() != test.vsize {
+			t.Errorf("%s: got weight %d & vsize %d, want %d & %d", test.name, tx.Weight, tx.VSize(), test.weight, test.vsize)
+		}
+		if tx.Inputs[0].PrevTxId != strings.Repeat("aa", 32) || tx.Inputs[0].Vout != 1 {
+			t.Errorf("%s: got input %+v", test.name, tx.Inputs[0])
+		}
+	}
+}
+
+func TestParseTxInvalid(t *testing.T) {
+	segwit := txVersion + "0001" + "01" + txInput + "01" + txOutput + txWitness + txLocktime
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"not hex", "zz" + segwit},
+		{"truncated", segwit[:len(segwit)-2]},
+		{"truncated witness", txVersion + "0001" + "01" + txInput + "01" + txOutput + "02" + "47"},
+		{"trailing bytes", segwit + "00"},
+		{"empty", ""},
+	}
+	for _, test := range tests {
+		if tx, err := ParseTx(test.raw); err == nil {
+			t.Errorf("%s: got %+v, want an error", test.name, tx)
+		}
+	}
+}
+
+func TestIndexedScriptSkipsOpReturn(t *testing.T) {
+	tx, err := ParseTx(txVersion + "01" + legacyInput + "02" + txOpReturn + txOutput + txLocktime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, ok := tx.IndexedScript()
+	if !ok || !bytes.Equal(script, tx.Outputs[1].Script) {
+		t.Errorf("got script %x, want the P2WPKH output's %x", script, tx.Outputs[1].Script)
+	}
+	if tx.Outputs[1].Value != 100000 {
+		t.Errorf("got value %d, want 100000", tx.Outputs[1].Value)
+	}
+}
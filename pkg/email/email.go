@@ -0,0 +1,297 @@
+package email
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	htmlTemplate "html/template"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	textTemplate "text/template"
+	"time"
+	"tx-tracker/pkg/mempool"
+	"tx-tracker/pkg/models"
+	"tx-tracker/pkg/utils"
+)
+
+// Config is the SMTP server the emails are sent through
+type Config struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	// StartTLS refuses to send over a server that doesn't offer STARTTLS, when false it is still used if offered
+	StartTLS bool
+	// Notify is who can be emailed, watches restored from before it was narrowed can still name others, who are skipped
+	Notify utils.NotifyPolicy
+}
+
+// Mailer emails the first confirmation and final messages of a watch to the addresses in its notify field
+type Mailer struct {
+	config Config
+	// from is the bare address of config.From, which can include a name
+	from *mail.Address
+	text *textTemplate.Template
+	html *htmlTemplate.Template
+}
+
+// Message is what the email templates are filled in with
+type Message struct {
+	Subject       string
+	Text          string
+	TxID          string
+	Address       string
+	Amount        string
+	Network       string
+	Confirmations int
+	Target        int
+	BlockHash     string
+	BlockHeight   int
+	BlockTime     string
+}
+
+const textBody = `{{.Text}}
+
+Transaction: {{.TxID}}
+{{- if .Address}}
+Address: {{.Address}}{{if .Amount}} received {{.Amount}}{{end}}
+{{- end}}
+Network: {{.Network}}
+Confirmations: {{.Confirmations}} of {{.Target}}
+{{- if .BlockHash}}
+Block: {{.BlockHeight}} {{.BlockHash}}{{if .BlockTime}} at {{.BlockTime}}{{end}}
+{{- end}}
+`
+
+const htmlBody = `<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif; color: #1d1c1d;">
+<div style="border-left: 4px solid #4af030; padding: 4px 12px;">
+<p>{{.Text}}</p>
+<table style="border-collapse: collapse;">
+<tr><td style="padding-right: 12px;"><b>Transaction</b></td><td><code>{{.TxID}}</code></td></tr>
+{{- if .Address}}
+<tr><td style="padding-right: 12px;"><b>Address</b></td><td><code>{{.Address}}</code>{{if .Amount}} received {{.Amount}}{{end}}</td></tr>
+{{- end}}
+<tr><td style="padding-right: 12px;"><b>Network</b></td><td>{{.Network}}</td></tr>
+<tr><td style="padding-right: 12px;"><b>Confirmations</b></td><td>{{.Confirmations}} of {{.Target}}</td></tr>
+{{- if .BlockHash}}
+<tr><td style="padding-right: 12px;"><b>Block</b></td><td>{{.BlockHeight}} <code>{{.BlockHash}}</code>{{if .BlockTime}} at {{.BlockTime}}{{end}}</td></tr>
+{{- end}}
+</table>
+</div>
+</body>
+</html>
+`
+
+func NewMailer(config Config) (*Mailer, error) {
+	if len(config.Host) == 0 || len(config.From) == 0 {
+		return nil, errors.New("an SMTP host and from address are required to send email")
+	}
+	if len(config.Port) == 0 {
+		config.Port = "587"
+	}
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address %s: %w", config.From, err)
+	}
+	text, err := textTemplate.New("text").Parse(textBody)
+	if err != nil {
+		return nil, err
+	}
+	html, err := htmlTemplate.New("html").Parse(htmlBody)
+	if err != nil {
+		return nil, err
+	}
+	return &Mailer{config: config, from: from, text: text, html: html}, nil
+}
+
+func newMessage(subject string, text string, watchTx models.WatchTx) Message {
+	message := Message{
+		Subject:       subject,
+		Text:          text,
+		TxID:          watchTx.TxID,
+		Address:       watchTx.Address,
+		Network:       utils.NormalizeNetwork(watchTx.Network),
+		Confirmations: watchTx.ConfsCount,
+		Target:        watchTx.Confs,
+		BlockHash:     watchTx.ConfirmBlockHash,
+		BlockHeight:   watchTx.ConfirmBlockHeight,
+	}
+	if watchTx.Amount > 0 {
+		message.Amount = utils.FormatSats(watchTx.Amount)
+	}
+	if watchTx.ConfirmBlockTime > 0 {
+		message.BlockTime = time.Unix(watchTx.ConfirmBlockTime, 0).UTC().Format("2006-01-02 15:04 MST")
+	}
+	return message
+}
+
+func (m *Mailer) SendErrorMessage(watchTx models.WatchTx, text string) {}
+
+func (m *Mailer) SendMempoolMessage(watchTx models.WatchTx) {}
+
+func (m *Mailer) SendDroppedMessage(watchTx models.WatchTx) {}
+
+func (m *Mailer) SendReplacedMessage(watchTx models.WatchTx) {}
+
+func (m *Mailer) SendReorgMessage(watchTx models.WatchTx, lostConfs int) {}
+
+func (m *Mailer) SendFirstConfMessage(watchTx models.WatchTx, confirmed models.ConfirmedPayload) {
+	if len(watchTx.Notify) == 0 {
+		return
+	}
+	if confirmed.BlockTime != nil {
+		watchTx.ConfirmBlockTime = int64(*confirmed.BlockTime)
+	}
+	subject := fmt.Sprintf("Transaction %s confirmed (%d of %d)", watchTx.TxID, watchTx.ConfsCount, watchTx.Confs)
+	m.sendAndLog(watchTx, newMessage(subject, mempool.FirstConfText(watchTx, confirmed), watchTx))
+}
+
+func (m *Mailer) SendUpdatedConfMessage(watchTx models.WatchTx) {}
+
+func (m *Mailer) SendFinalMessage(watchTx models.WatchTx) {
+	if len(watchTx.Notify) == 0 {
+		return
+	}
+	subject := fmt.Sprintf("Transaction %s settled with %d confirmations", watchTx.TxID, watchTx.ConfsCount)
+	m.sendAndLog(watchTx, newMessage(subject, mempool.FinalText(watchTx), watchTx))
+}
+
+func (m *Mailer) sendAndLog(watchTx models.WatchTx, message Message) {
+	to := []string{}
+	for _, address := range strings.Split(watchTx.Notify, ",") {
+		if !m.config.Notify.Allows(address) {
+			log.Printf("not emailing %s about %s, its domain isn't allowed", address, watchTx.ID)
+			continue
+		}
+		to = append(to, address)
+	}
+	if len(to) == 0 {
+		return
+	}
+	err := m.Send(to, message)
+	if err != nil {
+		log.Printf("failed to email %s about %s: %s", watchTx.Notify, watchTx.ID, err.Error())
+	}
+}
+
+// Send emails the message to each address with both a text and an HTML part
+func (m *Mailer) Send(to []string, message Message) error {
+	body, err := m.render(to, message)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(m.config.Host, m.config.Port), 30*time.Second)
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: m.config.Host})
+		if err != nil {
+			return err
+		}
+	} else if m.config.StartTLS {
+		return fmt.Errorf("%s does not support STARTTLS", m.config.Host)
+	}
+	if len(m.config.Username) > 0 {
+		//PlainAuth refuses to send the password over a connection that isn't encrypted, other than to localhost
+		err = client.Auth(smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host))
+		if err != nil {
+			return err
+		}
+	}
+	err = client.Mail(m.from.Address)
+	if err != nil {
+		return err
+	}
+	for _, address := range to {
+		err = client.Rcpt(address)
+		if err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// render builds the multipart/alternative email from the templates
+func (m *Mailer) render(to []string, message Message) ([]byte, error) {
+	var textPart, htmlPart bytes.Buffer
+	err := m.text.Execute(&textPart, message)
+	if err != nil {
+		return nil, err
+	}
+	err = m.html.Execute(&htmlPart, message)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	headers := []string{
+		"From: " + m.from.String(),
+		"To: " + strings.Join(to, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", message.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		fmt.Sprintf("Message-ID: <%d.tx-tracker@%s>", time.Now().UnixNano(), m.from.Address[strings.LastIndex(m.from.Address, "@")+1:]),
+		"MIME-Version: 1.0",
+		fmt.Sprintf("Content-Type: multipart/alternative; boundary=%q", parts.Boundary()),
+	}
+	var email bytes.Buffer
+	email.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{{"text/plain; charset=utf-8", textPart.Bytes()}, {"text/html; charset=utf-8", htmlPart.Bytes()}} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		_, err = qp.Write(part.content)
+		if err != nil {
+			return nil, err
+		}
+		err = qp.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = parts.Close()
+	if err != nil {
+		return nil, err
+	}
+	email.Write(body.Bytes())
+	return email.Bytes(), nil
+}
@@ -0,0 +1,316 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"tx-tracker/pkg/models"
+	"tx-tracker/pkg/utils"
+)
+
+// syncTimeout is how long the homeserver holds a /sync open waiting for new events
+const syncTimeout = 30 * time.Second
+
+// syncFilter keeps /sync down to the room messages and invites the bot acts on
+const syncFilter = `{"presence":{"types":[]},"account_data":{"types":[]},"room":{"state":{"lazy_load_members":true},"ephemeral":{"types":[]},"account_data":{"types":[]},"timeline":{"types":["m.room.message"]}}}`
+
+// Client talks to a Matrix homeserver over the client-server API, any homeserver works including a local
+// Synapse or Conduit, or a stub serving the same endpoints
+type Client struct {
+	HomeserverURL string
+	AccessToken   string
+	// UserID is filled in from /account/whoami by Login when it is empty
+	UserID      string
+	DisplayName string
+	client      *http.Client
+	nextTxn     int64
+}
+
+func NewClient(homeserverUrl string, accessToken string) *Client {
+	return &Client{
+		HomeserverURL: strings.TrimSuffix(homeserverUrl, "/"),
+		AccessToken:   accessToken,
+		client:        &http.Client{Timeout: syncTimeout + 30*time.Second},
+	}
+}
+
+// Error is a request the homeserver answered with a matrix error
+type Error struct {
+	StatusCode   int
+	ErrCode      string `json:"errcode"`
+	Message      string `json:"error"`
+	RetryAfterMs int    `json:"retry_after_ms"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("matrix %d %s: %s", e.StatusCode, e.ErrCode, e.Message)
+}
+
+// do sends a request to path under /_matrix/client/v3 and decodes the JSON answer into result, which can be nil
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body interface{}, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(raw)
+	}
+	endpoint := c.HomeserverURL + "/_matrix/client/v3" + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.AccessToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		matrixErr := &Error{StatusCode: resp.StatusCode}
+		if errJson := json.Unmarshal(raw, matrixErr); errJson != nil || len(matrixErr.ErrCode) == 0 {
+			matrixErr.Message = strings.TrimSpace(string(raw))
+		}
+		return matrixErr
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(raw, result)
+}
+
+// Login looks up who the access token belongs to and the display name it is mentioned by
+func (c *Client) Login(ctx context.Context) error {
+	if len(c.UserID) == 0 {
+		whoami := struct {
+			UserID string `json:"user_id"`
+		}{}
+		err := c.do(ctx, http.MethodGet, "/account/whoami", nil, nil, &whoami)
+		if err != nil {
+			return fmt.Errorf("failed to look up the matrix user: %w", err)
+		}
+		c.UserID = whoami.UserID
+	}
+	profile := struct {
+		DisplayName string `json:"displayname"`
+	}{}
+	err := c.do(ctx, http.MethodGet, "/profile/"+url.PathEscape(c.UserID)+"/displayname", nil, nil, &profile)
+	if err != nil {
+		log.Printf("failed to look up the matrix display name of %s: %s", c.UserID, err.Error())
+	}
+	c.DisplayName = profile.DisplayName
+	return nil
+}
+
+type SyncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join   map[string]JoinedRoom `json:"join"`
+		Invite map[string]struct{}   `json:"invite"`
+	} `json:"rooms"`
+}
+
+type JoinedRoom struct {
+	Timeline struct {
+		Events []Event `json:"events"`
+	} `json:"timeline"`
+}
+
+type Event struct {
+	Type    string       `json:"type"`
+	Sender  string       `json:"sender"`
+	EventID string       `json:"event_id"`
+	Content EventContent `json:"content"`
+}
+
+type EventContent struct {
+	MsgType       string    `json:"msgtype"`
+	Body          string    `json:"body"`
+	FormattedBody string    `json:"formatted_body,omitempty"`
+	Mentions      *Mentions `json:"m.mentions,omitempty"`
+}
+
+type Mentions struct {
+	UserIDs []string `json:"user_ids,omitempty"`
+	Room    bool     `json:"room,omitempty"`
+}
+
+// Sync returns the events after since, waiting up to timeout for one to arrive
+func (c *Client) Sync(ctx context.Context, since string, timeout time.Duration) (*SyncResponse, error) {
+	query := url.Values{}
+	query.Set("filter", syncFilter)
+	query.Set("timeout", fmt.Sprint(timeout.Milliseconds()))
+	if len(since) > 0 {
+		query.Set("since", since)
+	}
+	resp := &SyncResponse{}
+	err := c.do(ctx, http.MethodGet, "/sync", query, nil, resp)
+	return resp, err
+}
+
+func (c *Client) JoinRoom(ctx context.Context, roomId string) error {
+	return c.do(ctx, http.MethodPost, "/join/"+url.PathEscape(roomId), nil, struct{}{}, nil)
+}
+
+// SendNotice posts text to the room as an m.notice, the msgtype for bots
+func (c *Client) SendNotice(roomId string, text string) error {
+	return c.SendMessage(roomId, EventContent{MsgType: "m.notice", Body: text})
+}
+
+func (c *Client) SendMessage(roomId string, content EventContent) error {
+	txnId := fmt.Sprintf("tx-tracker-%d-%d", time.Now().UnixNano(), atomic.AddInt64(&c.nextTxn, 1))
+	path := fmt.Sprintf("/rooms/%s/send/m.room.message/%s", url.PathEscape(roomId), url.PathEscape(txnId))
+	return c.do(context.Background(), http.MethodPut, path, nil, content, nil)
+}
+
+// ListenForMatrixMessages syncs with the homeserver, joining the rooms the bot is invited to and handling mentions
+// of it until ctx is done. Messages sent while the bot was offline are skipped. The client has to have been
+// through Login first, mentions are recognised by its user id and display name.
+func ListenForMatrixMessages(ctx context.Context, client *Client, watchTransaction chan models.WatchTx, store *utils.WatchStore, notify utils.NotifyPolicy) error {
+	initial, err := client.Sync(ctx, "", 0)
+	if err != nil {
+		return fmt.Errorf("failed the first matrix sync: %w", err)
+	}
+	joinInvites(ctx, client, initial)
+	since := initial.NextBatch
+	for {
+		resp, err := client.Sync(ctx, since, syncTimeout)
+		if ctx.Err() != nil {
+			log.Println("Shutting down matrix listener")
+			return nil
+		}
+		if err != nil {
+			log.Printf("failed to sync with matrix: %s", err.Error())
+			wait := 5 * time.Second
+			var matrixErr *Error
+			if errors.As(err, &matrixErr) && matrixErr.RetryAfterMs > 0 {
+				wait = time.Duration(matrixErr.RetryAfterMs) * time.Millisecond
+			}
+			select {
+			case <-ctx.Done():
+				log.Println("Shutting down matrix listener")
+				return nil
+			case <-time.After(wait):
+			}
+			continue
+		}
+		since = resp.NextBatch
+		joinInvites(ctx, client, resp)
+		for roomId, room := range resp.Rooms.Join {
+			for _, event := range room.Timeline.Events {
+				if event.Type != "m.room.message" || event.Sender == client.UserID {
+					continue
+				}
+				text, mentioned := Mentioned(client, event.Content)
+				if !mentioned {
+					continue
+				}
+				err := HandleMentionToBot(roomId, text, client, watchTransaction, store, notify)
+				if err != nil {
+					log.Printf("failed to handle matrix message: %s", err.Error())
+				}
+			}
+		}
+	}
+}
+
+func joinInvites(ctx context.Context, client *Client, resp *SyncResponse) {
+	for roomId := range resp.Rooms.Invite {
+		log.Printf("joining matrix room %s", roomId)
+		err := client.JoinRoom(ctx, roomId)
+		if err != nil {
+			log.Printf("failed to join matrix room %s: %s", roomId, err.Error())
+		}
+	}
+}
+
+// Mentioned returns the text of a message after the bot's mention, and whether the bot was mentioned. Clients put
+// the display name of the mentioned user in the body, ie "tx-tracker: txId: ...", and newer ones list it in
+// m.mentions as well.
+func Mentioned(client *Client, content EventContent) (string, bool) {
+	body := strings.TrimSpace(content.Body)
+	localpart := strings.TrimPrefix(strings.SplitN(client.UserID, ":", 2)[0], "@")
+	for _, name := range []string{client.UserID, client.DisplayName, "@" + localpart, localpart} {
+		if len(name) > 0 && len(body) >= len(name) && strings.EqualFold(body[:len(name)], name) {
+			return strings.TrimLeft(body[len(name):], ":, "), true
+		}
+	}
+	if content.Mentions != nil {
+		for _, userId := range content.Mentions.UserIDs {
+			if userId == client.UserID {
+				return body, true
+			}
+		}
+	}
+	return body, strings.Contains(body, client.UserID)
+}
+
+func HandleMentionToBot(roomId string, text string, client *Client, watchTransaction chan models.WatchTx, store *utils.WatchStore, notify utils.NotifyPolicy) error {
+
+	switch utils.ParseCommand(text) {
+	case utils.CommandList:
+		return HandleListCommand(roomId, client, store)
+	case utils.CommandUnwatch:
+		watchTx, errConv := utils.ParseMessage(text)
+		if errConv != nil {
+			return client.SendNotice(roomId, fmt.Sprintf("Failed to stop watching, check your format? %s", errConv))
+		}
+		return HandleUnwatch(roomId, utils.WatchSubject(*watchTx), client, store)
+	case utils.CommandUpdate:
+		watchTx, errConv := utils.ParseUpdate(text)
+		if errConv != nil {
+			return client.SendNotice(roomId, fmt.Sprintf("Failed to update watcher, check your format? %s", errConv))
+		}
+		return HandleUpdate(roomId, utils.WatchSubject(*watchTx), watchTx.Confs, client, watchTransaction, store)
+	}
+
+	watchTx, errConv := utils.ParseWatch(text, notify)
+	if errConv != nil {
+		return client.SendNotice(roomId, fmt.Sprintf("Failed to setup watcher, check your format? %s", errConv))
+	}
+	watchTx.Channel = roomId
+	watchTx.Frontend = models.FrontendMatrix
+	watchTransaction <- *watchTx
+	return client.SendNotice(roomId, utils.WatchingText(*watchTx))
+}
+
+func HandleListCommand(roomId string, client *Client, store *utils.WatchStore) error {
+	return client.SendNotice(roomId, utils.ListText(store, models.FrontendMatrix, roomId))
+}
+
+func HandleUnwatch(roomId string, id string, client *Client, store *utils.WatchStore) error {
+	removed := utils.RemoveWatches(store, models.FrontendMatrix, roomId, id)
+	if len(removed) == 0 {
+		return client.SendNotice(roomId, utils.NotWatchedText(models.FrontendMatrix, id))
+	}
+	return client.SendNotice(roomId, utils.UnwatchedText(id))
+}
+
+func HandleUpdate(roomId string, id string, confs int, client *Client, watchTransaction chan models.WatchTx, store *utils.WatchStore) error {
+	updated := utils.UpdateWatchConfs(store, models.FrontendMatrix, roomId, id, confs, watchTransaction)
+	if len(updated) == 0 {
+		return client.SendNotice(roomId, utils.NotWatchedText(models.FrontendMatrix, id))
+	}
+	return client.SendNotice(roomId, utils.UpdatedText(id, confs, updated[0].ConfsCount))
+}
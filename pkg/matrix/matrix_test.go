@@ -0,0 +1,199 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tx-tracker/pkg/models"
+	"tx-tracker/pkg/utils"
+)
+
+const (
+	testUser    = "@tx-tracker:example.com"
+	invitedRoom = "!invited:example.com"
+	joinedRoom  = "!joined:example.com"
+)
+
+// stubHomeserver serves the client-server endpoints the bot uses, a first /sync with an invite, a second with a
+// mention of the bot and then nothing until the request is cancelled
+type stubHomeserver struct {
+	t      *testing.T
+	mutex  sync.Mutex
+	joined []string
+	sent   chan EventContent
+}
+
+func (s *stubHomeserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"errcode": "M_UNKNOWN_TOKEN", "error": "Unknown access token"})
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/_matrix/client/v3")
+	switch {
+	case path == "/account/whoami":
+		json.NewEncoder(w).Encode(map[string]string{"user_id": testUser})
+	case path == "/profile/"+testUser+"/displayname":
+		json.NewEncoder(w).Encode(map[string]string{"displayname": "TX Tracker"})
+	case path == "/sync":
+		s.sync(w, r)
+	case strings.HasPrefix(path, "/join/") && r.Method == http.MethodPost:
+		s.mutex.Lock()
+		s.joined = append(s.joined, strings.TrimPrefix(path, "/join/"))
+		s.mutex.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"room_id": strings.TrimPrefix(path, "/join/")})
+	case strings.HasPrefix(path, "/rooms/"+joinedRoom+"/send/m.room.message/") && r.Method == http.MethodPut:
+		content := EventContent{}
+		if err := json.NewDecoder(r.Body).Decode(&content); err != nil {
+			s.t.Errorf("failed to decode the sent message: %s", err)
+		}
+		s.sent <- content
+		json.NewEncoder(w).Encode(map[string]string{"event_id": "$sent"})
+	default:
+		s.t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *stubHomeserver) sync(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if !json.Valid([]byte(query.Get("filter"))) {
+		s.t.Errorf("the sync filter isn't JSON: %s", query.Get("filter"))
+	}
+	switch query.Get("since") {
+	case "":
+		if query.Get("timeout") != "0" {
+			s.t.Errorf("the first sync waited %sms for events, it should return straight away", query.Get("timeout"))
+		}
+		w.Write([]byte(`{"next_batch":"s1","rooms":{"invite":{"` + invitedRoom + `":{}}}}`))
+	case "s1":
+		w.Write([]byte(`{"next_batch":"s2","rooms":{"join":{"` + joinedRoom + `":{"timeline":{"events":[
+			{"type":"m.room.message","sender":"` + testUser + `","event_id":"$own","content":{"msgtype":"m.notice","body":"TX Tracker: list"}},
+			{"type":"m.room.message","sender":"@alice:example.com","event_id":"$other","content":{"msgtype":"m.text","body":"not for the bot"}},
+			{"type":"m.room.message","sender":"@alice:example.com","event_id":"$mention","content":{"msgtype":"m.text","body":"TX Tracker: list"}}
+		]}}}}}`))
+	default:
+		<-r.Context().Done()
+	}
+}
+
+func newStubClient(t *testing.T) (*Client, *stubHomeserver) {
+	stub := &stubHomeserver{t: t, sent: make(chan EventContent, 10)}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	return NewClient(server.URL+"/", "token"), stub
+}
+
+func TestLogin(t *testing.T) {
+	client, _ := newStubClient(t)
+	if err := client.Login(context.Background()); err != nil {
+		t.Fatalf("Login failed: %s", err)
+	}
+	if client.UserID != testUser || client.DisplayName != "TX Tracker" {
+		t.Errorf("got user %s named %s", client.UserID, client.DisplayName)
+	}
+}
+
+func TestListenJoinsInvitesAndAnswersMentions(t *testing.T) {
+	client, stub := newStubClient(t)
+	if err := client.Login(context.Background()); err != nil {
+		t.Fatalf("Login failed: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- ListenForMatrixMessages(ctx, client, make(chan models.WatchTx), utils.NewWatchStore(), utils.NotifyPolicy{})
+	}()
+
+	select {
+	case content := <-stub.sent:
+		if content.MsgType != "m.notice" || content.Body != "Nothing is being watched in this room" {
+			t.Errorf("got reply %+v", content)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the mention wasn't answered")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("the listener stopped with %s", err)
+	}
+
+	//only the mention is answered, not the bot's own message or the one that isn't for it
+	if len(stub.sent) != 0 {
+		t.Errorf("got %d more replies, want 1 in all", len(stub.sent)+1)
+	}
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	if len(stub.joined) != 1 || stub.joined[0] != invitedRoom {
+		t.Errorf("joined %v, want only %s", stub.joined, invitedRoom)
+	}
+}
+
+func TestErrorsAreDecoded(t *testing.T) {
+	client, _ := newStubClient(t)
+	client.AccessToken = "wrong"
+	err := client.JoinRoom(context.Background(), invitedRoom)
+	var matrixErr *Error
+	if !errors.As(err, &matrixErr) || matrixErr.StatusCode != http.StatusUnauthorized || matrixErr.ErrCode != "M_UNKNOWN_TOKEN" {
+		t.Errorf("got %v, want the M_UNKNOWN_TOKEN error", err)
+	}
+}
+
+func TestMentioned(t *testing.T) {
+	client := &Client{UserID: testUser, DisplayName: "TX Tracker"}
+	tests := []struct {
+		content   EventContent
+		text      string
+		mentioned bool
+	}{
+		{EventContent{Body: "TX Tracker: txId: aa confirms: 2"}, "txId: aa confirms: 2", true},
+		{EventContent{Body: "tx-tracker, list"}, "list", true},
+		{EventContent{Body: testUser + " list"}, "list", true},
+		{EventContent{Body: "list", Mentions: &Mentions{UserIDs: []string{testUser}}}, "list", true},
+		{EventContent{Body: "list", Mentions: &Mentions{UserIDs: []string{"@alice:example.com"}}}, "list", false},
+		{EventContent{Body: "txId: aa"}, "txId: aa", false},
+	}
+	for _, test := range tests {
+		text, mentioned := Mentioned(client, test.content)
+		if text != test.text || mentioned != test.mentioned {
+			t.Errorf("Mentioned(%q) = %q, %v, want %q, %v", test.content.Body, text, mentioned, test.text, test.mentioned)
+		}
+	}
+}
+
+// the text is the body with the mention of the bot already stripped by Mentioned
+func TestHandleMentionToBotCommands(t *testing.T) {
+	client, stub := newStubClient(t)
+	store := utils.NewWatchStore()
+	store.Add(models.WatchTx{TxID: "aa", Channel: joinedRoom, Frontend: models.FrontendMatrix, Confs: 6, ConfsCount: 1, TimeRequested: time.Now().UTC().Unix()})
+	//a watch from a slack channel with the same id isn't this room's
+	store.Add(models.WatchTx{TxID: "bb", Channel: joinedRoom, Confs: 6, TimeRequested: time.Now().UTC().Unix()})
+	watchTransaction := make(chan models.WatchTx, 1)
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "update txId: aa confirms: 3", want: utils.UpdatedText("aa", 3, 1)},
+		{text: "update txId: bb confirms: 3", want: utils.NotWatchedText(models.FrontendMatrix, "bb")},
+		{text: "unwatch txId: bb", want: "bb is not being watched in this room"},
+		{text: "unwatch txId: aa", want: utils.UnwatchedText("aa")},
+		{text: "list", want: "Nothing is being watched in this room"},
+	}
+	for _, test := range tests {
+		if err := HandleMentionToBot(joinedRoom, test.text, client, watchTransaction, store, utils.NotifyPolicy{}); err != nil {
+			t.Fatalf("%q failed: %s", test.text, err)
+		}
+		content := <-stub.sent
+		if content.Body != test.want {
+			t.Errorf("%q got %q, want %q", test.text, content.Body, test.want)
+		}
+	}
+}
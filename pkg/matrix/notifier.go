@@ -0,0 +1,74 @@
+package matrix
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"tx-tracker/pkg/mempool"
+	"tx-tracker/pkg/models"
+)
+
+// Notifier posts the messages about watches requested from matrix as notices in their room
+type Notifier struct {
+	Client *Client
+}
+
+func NewNotifier(client *Client) *Notifier {
+	return &Notifier{Client: client}
+}
+
+func (n *Notifier) send(watchTx models.WatchTx, content EventContent) {
+	err := n.Client.SendMessage(watchTx.Channel, content)
+	if err != nil {
+		log.Printf("failed to post matrix message: %s", err.Error())
+	}
+}
+
+func notice(text string) EventContent {
+	return EventContent{MsgType: "m.notice", Body: text}
+}
+
+func (n *Notifier) SendErrorMessage(watchTx models.WatchTx, text string) {
+	n.send(watchTx, notice(mempool.ErrorText(text)))
+}
+
+func (n *Notifier) SendMempoolMessage(watchTx models.WatchTx) {
+	n.send(watchTx, notice(mempool.MempoolText(watchTx)))
+}
+
+func (n *Notifier) SendDroppedMessage(watchTx models.WatchTx) {
+	n.send(watchTx, notice(mempool.DroppedText(watchTx)))
+}
+
+// SendReplacedMessage spells out the commands to move the watch, matrix has no buttons
+func (n *Notifier) SendReplacedMessage(watchTx models.WatchTx) {
+	watch := fmt.Sprintf("txId: %s confirms: %d", watchTx.ReplacedBy, watchTx.Confs)
+	if len(watchTx.Network) > 0 {
+		watch = fmt.Sprintf("%s network: %s", watch, watchTx.Network)
+	}
+	mention := n.Client.DisplayName
+	if len(mention) == 0 {
+		mention = n.Client.UserID
+	}
+	n.send(watchTx, notice(fmt.Sprintf("%s\nTo watch the replacement instead: \"%s: unwatch txId: %s\" then \"%s: %s\"", mempool.ReplacedText(watchTx), mention, watchTx.TxID, mention, watch)))
+}
+
+// SendReorgMessage pings the room, an @room in the body only notifies when m.mentions says so too
+func (n *Notifier) SendReorgMessage(watchTx models.WatchTx, lostConfs int) {
+	text := strings.Replace(mempool.ReorgText(watchTx, lostConfs), ":rotating_light:", "\U0001F6A8", 1)
+	content := notice("@room " + text)
+	content.Mentions = &Mentions{Room: true}
+	n.send(watchTx, content)
+}
+
+func (n *Notifier) SendFirstConfMessage(watchTx models.WatchTx, confirmed models.ConfirmedPayload) {
+	n.send(watchTx, notice(mempool.FirstConfText(watchTx, confirmed)))
+}
+
+func (n *Notifier) SendUpdatedConfMessage(watchTx models.WatchTx) {
+	n.send(watchTx, notice(mempool.UpdatedConfText(watchTx)))
+}
+
+func (n *Notifier) SendFinalMessage(watchTx models.WatchTx) {
+	n.send(watchTx, notice(mempool.FinalText(watchTx)))
+}
@@ -0,0 +1,36 @@
+package mempool
+
+import (
+	"context"
+	"tx-tracker/pkg/models"
+)
+
+// ChainBackend is the source of chain data used by the watcher, mempool.space is the default implementation
+type ChainBackend interface {
+	// GetLastBlockHeight returns the height of the current chain tip for the network
+	GetLastBlockHeight(network string) (*int, error)
+	// CheckTransactionWasConfirmed returns the confirmation status of a transaction on the network
+	CheckTransactionWasConfirmed(txId string, network string) (*models.ConfirmedPayload, error)
+	// GetTransaction returns the fee, size and inputs of a transaction, models.ErrTxNotFound when it is unknown
+	GetTransaction(txId string, network string) (*models.TxInfo, error)
+	// ListenForBlocks sends a models.NewBlock on newBlock for every block found on the network until ctx is done
+	ListenForBlocks(newBlock chan models.NewBlock, network string, ctx context.Context)
+}
+
+// ReplacementBackend is implemented by the backends that can find the transaction spending the inputs of another
+type ReplacementBackend interface {
+	// GetReplacement returns the txid of a different transaction spending any of inputs, empty when there is none
+	GetReplacement(txId string, network string, inputs []models.Outpoint) (string, error)
+}
+
+// AddressBackend is implemented by the backends that can look up the transactions paying an address
+type AddressBackend interface {
+	// GetAddressTransactions returns the mempool and confirmed transactions paying the address
+	GetAddressTransactions(address string, network string) ([]models.AddressTx, error)
+}
+
+// BlockBackend is implemented by the backends that can look up a block, and who mined it
+type BlockBackend interface {
+	// GetBlock returns the block with the hash, Extras is nil when the backend doesn't know them
+	GetBlock(blockHash string, network string) (*models.Block, error)
+}
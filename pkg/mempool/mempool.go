@@ -2,119 +2,16 @@ package mempool
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"math/big"
-	"net/http"
-	"net/url"
-	"regexp"
-	"strings"
 	"time"
 	"tx-tracker/pkg/models"
 	"tx-tracker/pkg/utils"
-
-	"github.com/gorilla/websocket"
-	"github.com/slack-go/slack"
 )
 
-func ListenForBlocks(newBlock chan models.NewBlock, network string, mempoolSpaceCtx context.Context) {
-	if network == "mainnet" {
-		network = ""
-	}
-	closeByApi, errRegex := regexp.Compile(`(close 1006 \(abnormal closure\))`)
-	if errRegex != nil {
-		log.Fatal(errRegex)
-	}
-	conn, err := SetupClient(network, mempoolSpaceCtx)
-	if err != nil {
-		return
-	}
-	log.Printf("listening to the blocks event from mempool.space")
-	for {
-		_, message, errRead := conn.ReadMessage()
-		if errRead != nil {
-
-			parseErr := closeByApi.Find([]byte(errRead.Error()))
-			if parseErr != nil {
-				log.Printf("api killed the connection, re-creating and continuing to listen")
-				break
-			}
-			log.Printf("error while reading message from mempool.space: %s", errRead.Error())
-			return
-		}
-		var objmap map[string]json.RawMessage
-		err := json.Unmarshal(message, &objmap)
-		if err != nil {
-			log.Printf("\nerror while unmarshalling top level block object %s", err.Error())
-			continue
-		}
-		var block models.Block
-		errBlock := json.Unmarshal(objmap["block"], &block)
-		if errBlock != nil {
-			log.Printf("\nerror while unmarshalling block object %s", errBlock.Error())
-			continue
-		}
-		log.Printf("\nnew block message %s", message)
-		newBlock <- models.NewBlock{IsNew: true, Network: network, BlockHeight: block.Height}
-	}
-	ListenForBlocks(newBlock, network, mempoolSpaceCtx)
-}
-
-func SetupClient(network string, mempoolSpaceCtx context.Context) (*websocket.Conn, error) {
-	if network == "mainnet" {
-		network = ""
-	}
-	connnectionString := url.URL{
-		Scheme: "wss",
-		Host:   "mempool.space",
-		Path:   fmt.Sprintf("%s/api/v1/ws", network),
-	}
-	urlStr := connnectionString.String()
-	log.Printf("\nconnecting to mempool.space websocket: %s", urlStr)
-
-	conn, _, err := websocket.DefaultDialer.DialContext(mempoolSpaceCtx, urlStr, nil)
-	if err != nil {
-		log.Printf("error connecting to mempool.space websocket %s", err.Error())
-		return nil, err
-	}
-	errWrite := conn.WriteJSON(models.MempoolListen{Action: "want", Data: []string{"blocks"}})
-	if errWrite != nil {
-		log.Printf("error setting up listen for 'blocks' %s", errWrite.Error())
-		return nil, errWrite
-	}
-	log.Println("setting up listen for 'blocks' from mempool.space websocket")
-	KeepAlive(conn, time.Second*120)
-
-	return conn, nil
-}
-
-func KeepAlive(c *websocket.Conn, timeout time.Duration) {
-	lastResponse := time.Now()
-	c.SetPongHandler(func(msg string) error {
-		lastResponse = time.Now()
-		return nil
-	})
-
-	go func() {
-		for {
-			err := c.WriteMessage(websocket.PingMessage, []byte("keepalive"))
-			if err != nil {
-				return
-			}
-			log.Println("Websocket ping message sent to mempool.space")
-			time.Sleep(timeout / 2)
-			if time.Since(lastResponse) > timeout {
-				c.Close()
-				return
-			}
-		}
-	}()
-}
-
-func ListenForUserTrans(set *utils.Set[models.WatchTx], watchTransaction chan models.WatchTx, newBlock chan models.NewBlock, slackClient *slack.Client, ctx context.Context) {
-	go func(set *utils.Set[models.WatchTx], watchTransaction chan models.WatchTx) {
+func ListenForUserTrans(backend ChainBackend, store *utils.WatchStore, watchTransaction chan models.WatchTx, newBlock chan models.NewBlock, notifier Notifier, ctx context.Context) {
+	go func(store *utils.WatchStore, watchTransaction chan models.WatchTx) {
 		for {
 			select {
 			case <-ctx.Done():
@@ -122,15 +19,39 @@ func ListenForUserTrans(set *utils.Set[models.WatchTx], watchTransaction chan mo
 				return
 			case newTransaction := <-watchTransaction:
 				log.Printf("New Transaction %v", newTransaction)
-				if !set.Contains(newTransaction) {
-					curTime := time.Now().UTC()
-					set.Add(newTransaction, curTime.Format("20060102150405"))
+				if len(newTransaction.ID) > 0 {
+					//an existing watch handed back after its target was lowered
+					FinishIfMet(store, newTransaction.ID, notifier)
+					continue
+				}
+				if _, ok := backend.(AddressBackend); len(newTransaction.Address) > 0 && !ok {
+					go notifier.SendErrorMessage(newTransaction, "the configured chain backend can't watch addresses, please watch by txId instead")
+					continue
+				}
+				if len(newTransaction.Address) > 0 && len(newTransaction.TxID) == 0 {
+					//only payments after the watch count, reused addresses already have some in their history
+					height, err := backend.GetLastBlockHeight(newTransaction.Network)
+					if err != nil {
+						go notifier.SendErrorMessage(newTransaction, "couldn't look up the chain tip to start watching the address from, please try again")
+						continue
+					}
+					newTransaction.WatchedFromHeight = *height
+				}
+				if watchTx, added := store.Add(newTransaction); !added {
+					log.Printf("already watching %s", watchTx.ID)
 				}
 			}
 		}
-	}(set, watchTransaction)
+	}(store, watchTransaction)
 
-	go func(set *utils.Set[models.WatchTx], slackClient *slack.Client, newBlock chan models.NewBlock) {
+	go func(store *utils.WatchStore, notifier Notifier, newBlock chan models.NewBlock) {
+		//addresses and the mempool are polled between blocks so transactions are reported as soon as they are seen
+		mempoolTicker := time.NewTicker(time.Second * 30)
+		defer mempoolTicker.Stop()
+		//a reorg while the bot was down can only be caught by re-checking the confirmed watches
+		CheckNetworksForReorg(backend, store, notifier)
+		//hash of the last block seen on each network, a new block not building on it means the chain reorged
+		tips := make(map[string]string)
 		for {
 			select {
 			case <-ctx.Done():
@@ -139,158 +60,522 @@ func ListenForUserTrans(set *utils.Set[models.WatchTx], watchTransaction chan mo
 			case newBlc := <-newBlock:
 				log.Printf("New Block %v", newBlc)
 				if newBlc.IsNew {
-					SendMessageForWatched(set, newBlc.Network, newBlc.BlockHeight, slackClient)
+					lastTip, seen := tips[newBlc.Network]
+					if !seen {
+						//nothing to compare the first block with, it could follow a reorg missed while disconnected
+						CheckForReorg(backend, store, newBlc.Network, newBlc.BlockHeight, notifier)
+					} else if len(newBlc.PreviousBlockHash) > 0 && newBlc.PreviousBlockHash != lastTip {
+						log.Printf("reorg on %s, block %s does not build on %s", newBlc.Network, newBlc.BlockHash, lastTip)
+						CheckForReorg(backend, store, newBlc.Network, newBlc.BlockHeight, notifier)
+					}
+					tips[newBlc.Network] = newBlc.BlockHash
+					SendMessageForWatched(backend, store, newBlc.Network, newBlc.BlockHeight, notifier)
 				}
+			case <-mempoolTicker.C:
+				CheckWatchedAddresses(backend, store, notifier)
+				CheckMempool(backend, store, notifier)
 			default:
 				time.Sleep(time.Second * 2)
 			}
 		}
-	}(set, slackClient, newBlock)
+	}(store, notifier, newBlock)
 
 }
 
-func SendMessageForWatched(set *utils.Set[models.WatchTx], network string, curBlockHeight int, slackClient *slack.Client) {
+// SendMessageForWatched brings every watch on the network up to date with the chain tip at curBlockHeight.
+// Confirmations are derived from the height of the block holding the transaction, so missed block events
+// or downtime are caught up on the next call and several confirmations landing at once are reported together.
+// The chain is asked about each transaction once, however many channels are watching it.
+func SendMessageForWatched(backend ChainBackend, store *utils.WatchStore, network string, curBlockHeight int, notifier Notifier) {
 
-	for _, watchTx := range set.Keys() {
-		log.Printf("\nnetwork: %s watchTx: %v curBlockHeight: %d", network, watchTx, curBlockHeight)
+	for _, group := range store.Grouped(network) {
+		log.Printf("\nnetwork: %s watching: %s (%d watches) curBlockHeight: %d", network, utils.TxKey(group[0]), len(group), curBlockHeight)
 
-		if watchTx.Network != network {
-			log.Println("\n skipping")
-			continue
+		if len(group[0].TxID) == 0 {
+			found, ok := FindAddressTransaction(backend, store, group, notifier)
+			if !ok {
+				continue
+			}
+			group = found
 		}
 
-		if watchTx.ConfsCount > 0 && (watchTx.ConfsCount+1) != watchTx.Confs && watchTx.ConfirmBlockHeight < curBlockHeight {
-			log.Printf("\nwatching transaction has confsCount >0: %v", watchTx)
-			set.Remove(watchTx)
-			log.Printf("\nwatchTx %v", watchTx)
-			watchTx.ConfsCount = watchTx.ConfsCount + 1
-			watchTx.ConfirmBlockHeight = curBlockHeight
-			curTime := time.Now().UTC()
-			log.Printf("\nwatchTx %v", watchTx)
-			set.Add(watchTx, curTime.Format("20060102150405"))
-			go SendUpdatedConfMessage(watchTx, slackClient)
-		} else if watchTx.ConfsCount == 0 {
-			log.Printf("watching transaction has confsCount = 0: %v", watchTx)
-			//check if in recent block
-			confirmed, err := CheckTransactionWasConfirmed(watchTx.TxID, watchTx.Network)
+		var confirmed *models.ConfirmedPayload
+		var info *models.TxInfo
+		pool := ""
+		if needsConfirmingBlock(group) {
+			log.Printf("watching transaction has no confirming block yet: %s", group[0].TxID)
+			status, err := backend.CheckTransactionWasConfirmed(group[0].TxID, group[0].Network)
 			if err != nil {
 				log.Println(err)
 				continue
 			}
-			if !confirmed.Confirmed {
+			if !status.Confirmed || status.BlockHeight == nil {
 				continue
 			}
-			log.Printf("confirmed results %v", confirmed)
-			set.Remove(watchTx)
-			watchTx.ConfsCount = watchTx.ConfsCount + 1
-			watchTx.ConfirmBlockHeight = curBlockHeight
-			curTime := time.Now().UTC()
-			log.Printf("watchTx %v", watchTx)
-			set.Add(watchTx, curTime.Format("20060102150405"))
-			go SendFirstConfMessage(watchTx, *confirmed, slackClient)
-		} else if (watchTx.ConfsCount + 1) == watchTx.Confs {
-			log.Printf("removing watchTx %v", watchTx)
-			set.Remove(watchTx)
-			watchTx.ConfsCount = watchTx.Confs
-			go SendFinalMessage(watchTx, slackClient)
-		} else {
-			log.Printf("\n didnt hit any matching")
-		}
-	}
-
-	utils.RemoveOldItems(set, time.Now().UTC().Unix())
+			log.Printf("confirmed results %v", status)
+			confirmed = status
+			pool = MiningPool(backend, status, group[0].Network)
+			info = FeeInfo(backend, group)
+		}
+
+		for _, watchTx := range group {
+			AdvanceWatch(store, watchTx, confirmed, info, pool, curBlockHeight, notifier)
+		}
+	}
+
+	utils.RemoveOldItems(store, time.Now().UTC().Unix())
 }
 
-func CheckTransactionWasConfirmed(txId string, network string) (*models.ConfirmedPayload, error) {
-	if network == "mainnet" {
-		network = ""
+// needsConfirmingBlock is true when any watch in the group doesn't know which block confirmed the transaction yet,
+// watches saved before the block hash was tracked only know how many blocks they counted so are looked up again
+func needsConfirmingBlock(group []models.WatchTx) bool {
+	for _, watchTx := range group {
+		if watchTx.ConfirmBlockHeight == 0 || len(watchTx.ConfirmBlockHash) == 0 {
+			return true
+		}
 	}
-	mempoolSpaceUrl := ""
-	if len(network) > 0 {
-		lowerNetwork := strings.ToLower(network)
-		mempoolSpaceUrl = fmt.Sprintf("https://mempool.space/%s/api/tx/%s/status", lowerNetwork, txId)
-	} else {
-		mempoolSpaceUrl = fmt.Sprintf("https://mempool.space/api/tx/%s/status", txId)
+	return false
+}
+
+// FeeInfo looks up the fee and size of the group's transaction when a watch on it doesn't know them yet, which
+// happens when it confirmed before it was seen in the mempool. It's nil when they're known or can't be found.
+func FeeInfo(backend ChainBackend, group []models.WatchTx) *models.TxInfo {
+	for _, watchTx := range group {
+		if watchTx.VSize > 0 {
+			continue
+		}
+		info, err := backend.GetTransaction(watchTx.TxID, watchTx.Network)
+		if err != nil {
+			log.Printf("failed to look up the fee of %s: %s", watchTx.TxID, err.Error())
+			return nil
+		}
+		return info
 	}
-	resp, err := http.Get(mempoolSpaceUrl)
-	if err != nil {
-		log.Printf("failed to request out to mempool.space")
-		return nil, err
+	return nil
+}
+
+// AdvanceWatch updates a single watch to the tip at curBlockHeight and sends the matching notification,
+// confirmed is the transaction's status when it was looked up for this block, info its fee and size when they
+// weren't known and pool who mined it
+func AdvanceWatch(store *utils.WatchStore, watchTx models.WatchTx, confirmed *models.ConfirmedPayload, info *models.TxInfo, pool string, curBlockHeight int, notifier Notifier) {
+	previous := watchTx
+	if watchTx.ConfirmBlockHeight == 0 || len(watchTx.ConfirmBlockHash) == 0 {
+		if confirmed == nil {
+			return
+		}
+		watchTx.ConfirmBlockHeight = *confirmed.BlockHeight
+		if confirmed.BlockHash != nil {
+			watchTx.ConfirmBlockHash = *confirmed.BlockHash
+		}
+		if confirmed.BlockTime != nil {
+			watchTx.ConfirmBlockTime = int64(*confirmed.BlockTime)
+		}
+		watchTx.ConfirmBlockPool = pool
+		if info != nil && watchTx.VSize == 0 {
+			watchTx.Fee = info.Fee
+			watchTx.VSize = info.VSize
+		}
 	}
-	if resp.Body != nil {
-		defer resp.Body.Close()
+	watchTx.State = models.StateConfirmed
+
+	tipHeight := curBlockHeight
+	if tipHeight < watchTx.ConfirmBlockHeight {
+		//the backend can know about a block before its event reaches us
+		tipHeight = watchTx.ConfirmBlockHeight
 	}
-	body, readErr := io.ReadAll(resp.Body)
-	if readErr != nil {
-		log.Printf("failed to read body from response of mempool.space")
-		return nil, readErr
+	watchTx.ConfsCount = Confirmations(watchTx.ConfirmBlockHeight, tipHeight)
+
+	if watchTx.ConfsCount >= watchTx.Confs {
+		log.Printf("removing watchTx %v", watchTx)
+		if _, ok := store.Remove(watchTx.ID, models.FinishCompleted); ok {
+			go notifier.SendFinalMessage(watchTx)
+		}
+		return
 	}
-	log.Printf("\npayload %s", body)
-	confirmed := &models.ConfirmedPayload{}
-	errMarshal := json.Unmarshal(body, confirmed)
-	if errMarshal != nil {
-		log.Printf("failed to unmarshal body from response of mempool.space")
-		return nil, errMarshal
+	if watchTx == previous {
+		log.Printf("\n no new confirmations for %s", watchTx.ID)
+		return
+	}
+	updated, ok := store.Update(watchTx.ID, func(stored *models.WatchTx) {
+		stored.ConfirmBlockHeight = watchTx.ConfirmBlockHeight
+		stored.ConfirmBlockHash = watchTx.ConfirmBlockHash
+		stored.ConfirmBlockTime = watchTx.ConfirmBlockTime
+		stored.ConfirmBlockPool = watchTx.ConfirmBlockPool
+		stored.Fee = watchTx.Fee
+		stored.VSize = watchTx.VSize
+		stored.ConfsCount = watchTx.ConfsCount
+		stored.State = watchTx.State
+	})
+	if !ok {
+		return
+	}
+	log.Printf("watchTx %v", updated)
+	if previous.ConfsCount == 0 && confirmed != nil {
+		go notifier.SendFirstConfMessage(updated, *confirmed)
+	} else if updated.ConfsCount > previous.ConfsCount {
+		go notifier.SendUpdatedConfMessage(updated)
 	}
-	return confirmed, nil
 }
 
-func GetLastBlockHeight(network string) (*int, error) {
-	if network == "mainnet" {
-		network = ""
+// FinishIfMet finishes the watch with the id once it has as many confirmations as its target, sending the final message
+func FinishIfMet(store *utils.WatchStore, id string, notifier Notifier) {
+	watchTx, ok := store.Get(id)
+	if !ok || watchTx.ConfsCount < watchTx.Confs {
+		return
 	}
-	mempoolSpaceUrl := ""
-	if len(network) > 0 {
-		lowerNetwork := strings.ToLower(network)
-		mempoolSpaceUrl = fmt.Sprintf("https://mempool.space/%s/api/blocks/tip/height", lowerNetwork)
-	} else {
-		mempoolSpaceUrl = "https://mempool.space/api/blocks/tip/height"
+	if _, ok := store.Remove(id, models.FinishCompleted); ok {
+		go notifier.SendFinalMessage(watchTx)
 	}
-	resp, err := http.Get(mempoolSpaceUrl)
+}
+
+// MiningPool returns the name of the pool that mined the block confirming the transaction, empty when it's
+// unconfirmed or the backend can't tell
+func MiningPool(backend ChainBackend, confirmed *models.ConfirmedPayload, network string) string {
+	blockBackend, ok := backend.(BlockBackend)
+	if !ok || !confirmed.Confirmed || confirmed.BlockHash == nil {
+		return ""
+	}
+	block, err := blockBackend.GetBlock(*confirmed.BlockHash, network)
 	if err != nil {
-		log.Printf("failed to request out to mempool.space")
-		return nil, err
+		log.Printf("failed to look up the pool of block %s: %s", *confirmed.BlockHash, err.Error())
+		return ""
+	}
+	if block.Extras == nil || block.Extras.Pool == nil {
+		return ""
+	}
+	return block.Extras.Pool.Name
+}
+
+// Confirmations returns how many confirmations a transaction in the block at confirmHeight has with the tip at tipHeight
+func Confirmations(confirmHeight int, tipHeight int) int {
+	if confirmHeight <= 0 || tipHeight < confirmHeight {
+		return 0
 	}
-	if resp.Body != nil {
-		defer resp.Body.Close()
+	return tipHeight - confirmHeight + 1
+}
+
+// SameNetwork compares network names, watches on mainnet are stored with an empty network
+func SameNetwork(a string, b string) bool {
+	return utils.NormalizeNetwork(a) == utils.NormalizeNetwork(b)
+}
+
+// CheckNetworksForReorg runs CheckForReorg on every network with watches, against its current tip
+func CheckNetworksForReorg(backend ChainBackend, store *utils.WatchStore, notifier Notifier) {
+	checked := map[string]bool{}
+	for _, watchTx := range store.All() {
+		network := watchTx.Network
+		if checked[utils.NormalizeNetwork(network)] {
+			continue
+		}
+		checked[utils.NormalizeNetwork(network)] = true
+		height, err := backend.GetLastBlockHeight(network)
+		if err != nil {
+			log.Printf("failed to get the tip of %s to check for reorgs: %s", utils.NormalizeNetwork(network), err.Error())
+			continue
+		}
+		CheckForReorg(backend, store, network, *height, notifier)
 	}
-	heightRaw, readErr := io.ReadAll(resp.Body)
-	if readErr != nil {
-		log.Printf("failed to read body from response of mempool.space")
-		return nil, readErr
+}
+
+// CheckForReorg re-checks every confirmed transaction on the network after a reorg, rolling back the confirmations
+// of the watches whose block is no longer on the best chain
+func CheckForReorg(backend ChainBackend, store *utils.WatchStore, network string, curBlockHeight int, notifier Notifier) {
+	for _, group := range store.Grouped(network) {
+		confirmedWatches := []models.WatchTx{}
+		for _, watchTx := range group {
+			if watchTx.ConfsCount > 0 && len(watchTx.ConfirmBlockHash) > 0 {
+				confirmedWatches = append(confirmedWatches, watchTx)
+			}
+		}
+		if len(confirmedWatches) == 0 {
+			continue
+		}
+		confirmed, err := backend.CheckTransactionWasConfirmed(group[0].TxID, group[0].Network)
+		if err != nil {
+			log.Printf("failed to re-check %s after reorg: %s", group[0].TxID, err.Error())
+			continue
+		}
+		pool := MiningPool(backend, confirmed, group[0].Network)
+		for _, watchTx := range confirmedWatches {
+			if confirmed.Confirmed && confirmed.BlockHash != nil && *confirmed.BlockHash == watchTx.ConfirmBlockHash {
+				continue
+			}
+			updated, ok := store.Update(watchTx.ID, func(stored *models.WatchTx) {
+				if confirmed.Confirmed && confirmed.BlockHash != nil && confirmed.BlockHeight != nil {
+					//confirmed again in a block on the new chain
+					stored.ConfirmBlockHeight = *confirmed.BlockHeight
+					stored.ConfirmBlockHash = *confirmed.BlockHash
+					stored.ConfirmBlockTime = 0
+					stored.ConfirmBlockPool = pool
+					if confirmed.BlockTime != nil {
+						stored.ConfirmBlockTime = int64(*confirmed.BlockTime)
+					}
+					tipHeight := curBlockHeight
+					if tipHeight < stored.ConfirmBlockHeight {
+						//the backend can know about a block before its event reaches us
+						tipHeight = stored.ConfirmBlockHeight
+					}
+					stored.ConfsCount = Confirmations(stored.ConfirmBlockHeight, tipHeight)
+				} else {
+					stored.ConfsCount = 0
+					stored.ConfirmBlockHeight = 0
+					stored.ConfirmBlockHash = ""
+					stored.ConfirmBlockTime = 0
+					stored.ConfirmBlockPool = ""
+					stored.State = models.StateWatching
+				}
+			})
+			if !ok {
+				continue
+			}
+			lostConfs := watchTx.ConfsCount - updated.ConfsCount
+			log.Printf("reorged watchTx %v lost %d confirmations", updated, lostConfs)
+			if lostConfs > 0 {
+				go notifier.SendReorgMessage(updated, lostConfs)
+			}
+		}
 	}
-	log.Printf("\nLast Block Height %s", heightRaw)
-	height := int(big.NewInt(0).SetBytes(heightRaw).Uint64())
+}
 
-	return &height, nil
+// CheckMempool follows every unconfirmed transaction in and out of the mempool, reporting when it is first seen
+// and when it is dropped (evicted or replaced) before confirming
+func CheckMempool(backend ChainBackend, store *utils.WatchStore, notifier Notifier) {
+	for _, group := range store.Groups() {
+		if len(group[0].TxID) == 0 {
+			continue
+		}
+		pending := []models.WatchTx{}
+		for _, watchTx := range group {
+			if watchTx.State != models.StateConfirmed && watchTx.State != models.StateReplaced {
+				pending = append(pending, watchTx)
+			}
+		}
+		if len(pending) > 0 {
+			UpdateMempoolState(backend, store, pending, notifier)
+		}
+	}
 }
 
-func SendFirstConfMessage(watchTx models.WatchTx, confirmed models.ConfirmedPayload, slackClient *slack.Client) {
-	attachment := slack.Attachment{}
-	attachment.Text = fmt.Sprintf("Your transaction %s has been picked up from the mempool and confirmed in block %s at %s! ", watchTx.TxID, *confirmed.BlockHash, utils.ConvertTimestamp(*confirmed.BlockTime))
-	attachment.Color = "#4af030"
-	_, _, err := slackClient.PostMessage(watchTx.Channel, slack.MsgOptionAttachments(attachment))
+// UpdateMempoolState moves the watches on a transaction to the mempool, dropped or replaced state,
+// confirmations are left to the block listener
+func UpdateMempoolState(backend ChainBackend, store *utils.WatchStore, group []models.WatchTx, notifier Notifier) {
+	txId := group[0].TxID
+	info, err := backend.GetTransaction(txId, group[0].Network)
+	if errors.Is(err, models.ErrTxNotFound) {
+		replacement := ""
+		lookedForReplacement := false
+		for _, watchTx := range group {
+			if watchTx.State != models.StateInMempool && watchTx.State != models.StateDropped {
+				continue
+			}
+			if !lookedForReplacement {
+				replacement = FindReplacement(backend, watchTx)
+				lookedForReplacement = true
+			}
+			if len(replacement) > 0 {
+				log.Printf("watchTx %v replaced by %s", watchTx, replacement)
+				updated, ok := store.Update(watchTx.ID, func(stored *models.WatchTx) {
+					stored.State = models.StateReplaced
+					stored.ReplacedBy = replacement
+				})
+				if ok {
+					go notifier.SendReplacedMessage(updated)
+				}
+				continue
+			}
+			if watchTx.State == models.StateDropped {
+				continue
+			}
+			log.Printf("watchTx %v dropped from the mempool", watchTx)
+			updated, ok := store.Update(watchTx.ID, func(stored *models.WatchTx) {
+				stored.State = models.StateDropped
+			})
+			if ok {
+				go notifier.SendDroppedMessage(updated)
+			}
+		}
+		return
+	}
 	if err != nil {
-		log.Printf("failed to post message: %s", err.Error())
+		log.Printf("failed to look up %s in the mempool: %s", txId, err.Error())
+		return
+	}
+	if info.Confirmed {
+		return
+	}
+	for _, watchTx := range group {
+		if watchTx.State == models.StateInMempool {
+			continue
+		}
+		log.Printf("watchTx %v seen in the mempool", watchTx)
+		updated, ok := store.Update(watchTx.ID, func(stored *models.WatchTx) {
+			stored.State = models.StateInMempool
+			stored.Fee = info.Fee
+			stored.VSize = info.VSize
+			stored.Inputs = models.JoinOutpoints(info.Inputs)
+		})
+		if ok {
+			go notifier.SendMempoolMessage(updated)
+		}
 	}
 }
 
-func SendUpdatedConfMessage(watchTx models.WatchTx, slackClient *slack.Client) {
-	attachment := slack.Attachment{}
-	attachment.Text = fmt.Sprintf("Your transaction %s has moved up a confirmation %d", watchTx.TxID, watchTx.ConfsCount)
-	attachment.Color = "#4af030"
-	_, _, err := slackClient.PostMessage(watchTx.Channel, slack.MsgOptionAttachments(attachment))
+// FindReplacement returns the txid of the transaction that spent the inputs of the watched one, empty when
+// there is none or the backend can't look it up
+func FindReplacement(backend ChainBackend, watchTx models.WatchTx) string {
+	replacementBackend, ok := backend.(ReplacementBackend)
+	if !ok || len(watchTx.Inputs) == 0 {
+		return ""
+	}
+	replacement, err := replacementBackend.GetReplacement(watchTx.TxID, watchTx.Network, models.SplitOutpoints(watchTx.Inputs))
 	if err != nil {
-		log.Printf("failed to post message: %s", err.Error())
+		log.Printf("failed to look for a replacement of %s: %s", watchTx.TxID, err.Error())
+		return ""
 	}
+	return replacement
 }
 
-func SendFinalMessage(watchTx models.WatchTx, slackClient *slack.Client) {
-	attachment := slack.Attachment{}
-	attachment.Text = fmt.Sprintf("The transaction %s has moved up to your limit of confirmations %d and you will no longer be notified", watchTx.TxID, watchTx.ConfsCount)
-	attachment.Color = "#4af030"
-	_, _, err := slackClient.PostMessage(watchTx.Channel, slack.MsgOptionAttachments(attachment))
+// CheckWatchedAddresses looks for the first transaction paying each watched address that hasn't been paid yet
+func CheckWatchedAddresses(backend ChainBackend, store *utils.WatchStore, notifier Notifier) {
+	for _, group := range store.Groups() {
+		if len(group[0].Address) == 0 || len(group[0].TxID) > 0 {
+			continue
+		}
+		FindAddressTransaction(backend, store, group, notifier)
+	}
+}
+
+// FindAddressTransaction moves the watches on an address on to the first transaction paying it since the watch was
+// added, see FirstPayment, returning the updated watches once one is found
+func FindAddressTransaction(backend ChainBackend, store *utils.WatchStore, group []models.WatchTx, notifier Notifier) ([]models.WatchTx, bool) {
+	addressBackend, ok := backend.(AddressBackend)
+	if !ok {
+		return group, false
+	}
+	address := group[0].Address
+	addressTxs, err := addressBackend.GetAddressTransactions(address, group[0].Network)
 	if err != nil {
-		log.Printf("failed to post message: %s", err.Error())
+		log.Printf("failed to look up transactions for address %s: %s", address, err.Error())
+		return group, false
+	}
+	//watches added at different heights can be waiting on different payments, each moves on to the first one made
+	//after it was added and the watches paid by the same transaction as the first are returned
+	var payment *models.AddressTx
+	paid := []models.WatchTx{}
+	for _, watchTx := range group {
+		watchPayment := FirstPayment(addressTxs, watchTx.WatchedFromHeight)
+		if watchPayment == nil {
+			continue
+		}
+		updated, ok := store.Update(watchTx.ID, func(stored *models.WatchTx) {
+			stored.TxID = watchPayment.TxID
+			stored.Amount = watchPayment.Amount
+		})
+		if !ok {
+			continue
+		}
+		log.Printf("address %s paid by %v", address, watchPayment)
+		if payment == nil {
+			payment = watchPayment
+		}
+		if watchPayment.TxID == payment.TxID {
+			paid = append(paid, updated)
+		}
 	}
+	if len(paid) == 0 {
+		return group, false
+	}
+	if !payment.Confirmed {
+		UpdateMempoolState(backend, store, paid, notifier)
+		for i := range paid {
+			if stored, ok := store.Get(paid[i].ID); ok {
+				paid[i] = stored
+			}
+		}
+	}
+	return paid, true
+}
+
+// FirstPayment returns the earliest payment confirmed above fromHeight, or one in the mempool while none has
+// confirmed, nil when the address hasn't been paid since
+func FirstPayment(addressTxs []models.AddressTx, fromHeight int) *models.AddressTx {
+	var payment *models.AddressTx
+	for i, addressTx := range addressTxs {
+		if addressTx.Confirmed && addressTx.BlockHeight <= fromHeight {
+			continue
+		}
+		if payment == nil || (addressTx.Confirmed && (!payment.Confirmed || addressTx.BlockHeight < payment.BlockHeight)) {
+			payment = &addressTxs[i]
+		}
+	}
+	return payment
+}
+
+// amountReceived describes what a watched address was paid, empty for txId watches
+func amountReceived(watchTx models.WatchTx) string {
+	if len(watchTx.Address) == 0 || watchTx.Amount == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%s received by %s)", utils.FormatSats(watchTx.Amount), watchTx.Address)
+}
+
+// the text of each message, shared by the notifiers of every frontend
+
+func ErrorText(text string) string {
+	return fmt.Sprintf("Failed to setup watcher: %s", text)
+}
+
+func MempoolText(watchTx models.WatchTx) string {
+	return fmt.Sprintf("Your transaction %s has been seen in the mempool paying %.1f sat/vB (%d vB)%s", watchTx.TxID, watchTx.FeeRate(), watchTx.VSize, amountReceived(watchTx))
+}
+
+func DroppedText(watchTx models.WatchTx) string {
+	return fmt.Sprintf("Your transaction %s has been dropped from the mempool, it was either evicted or replaced. It will keep being watched in case it is broadcast again", watchTx.TxID)
+}
+
+func ReplacedText(watchTx models.WatchTx) string {
+	return fmt.Sprintf("Your transaction %s has been replaced, its inputs were spent by %s", watchTx.TxID, watchTx.ReplacedBy)
+}
+
+// ReorgText is the reorg alert, each frontend puts its own @here mention in front of it
+func ReorgText(watchTx models.WatchTx, lostConfs int) string {
+	return fmt.Sprintf(":rotating_light: reorg: your transaction %s lost %d confirmations and is now at %d confirmations", watchTx.TxID, lostConfs, watchTx.ConfsCount)
+}
+
+func FirstConfText(watchTx models.WatchTx, confirmed models.ConfirmedPayload) string {
+	return fmt.Sprintf("Your transaction %s has been picked up from the mempool and confirmed in block %s at %s, it has %d of %d confirmations!%s", watchTx.TxID, *confirmed.BlockHash, utils.ConvertTimestamp(*confirmed.BlockTime), watchTx.ConfsCount, watchTx.Confs, amountReceived(watchTx))
+}
+
+func UpdatedConfText(watchTx models.WatchTx) string {
+	return fmt.Sprintf("Your transaction %s now has %d of %d confirmations%s", watchTx.TxID, watchTx.ConfsCount, watchTx.Confs, amountReceived(watchTx))
+}
+
+func FinalText(watchTx models.WatchTx) string {
+	return fmt.Sprintf("The transaction %s has moved up to your limit of confirmations %d and you will no longer be notified%s", watchTx.TxID, watchTx.ConfsCount, amountReceived(watchTx))
+}
+
+// StatusText describes how far along a watch is, for its status message
+func StatusText(watchTx models.WatchTx) string {
+	subject := watchTx.TxID
+	state := "not seen yet"
+	if len(watchTx.TxID) == 0 {
+		subject = "address " + watchTx.Address
+		state = "waiting for a payment"
+	}
+	switch watchTx.State {
+	case models.StateInMempool:
+		state = fmt.Sprintf("in the mempool paying %.1f sat/vB", watchTx.FeeRate())
+	case models.StateConfirmed:
+		state = fmt.Sprintf("confirmed in block %d", watchTx.ConfirmBlockHeight)
+	case models.StateDropped:
+		state = "dropped from the mempool"
+	case models.StateReplaced:
+		state = "replaced by " + watchTx.ReplacedBy
+	}
+	return fmt.Sprintf(":eyes: Watching %s on %s: %d of %d confirmations, %s%s", subject, utils.NormalizeNetwork(watchTx.Network), watchTx.ConfsCount, watchTx.Confs, state, amountReceived(watchTx))
+}
+
+// SettledText is the status of a watch that reached its target
+func SettledText(watchTx models.WatchTx) string {
+	return fmt.Sprintf(":white_check_mark: %s reached %d of %d confirmations and is no longer watched%s", watchTx.TxID, watchTx.ConfsCount, watchTx.Confs, amountReceived(watchTx))
 }
@@ -0,0 +1,562 @@
+package mempool
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"tx-tracker/pkg/models"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	FlavorMempool = "mempool"
+	// FlavorEsplora is Blockstream's Esplora, it shares the REST api with mempool but has no websocket
+	FlavorEsplora = "esplora"
+)
+
+// Instance is the mempool or Esplora deployment serving a single network
+type Instance struct {
+	// RestURL is the base of the REST api, ie https://mempool.space/testnet/api
+	RestURL string
+	// WebsocketURL is the mempool websocket, ie wss://mempool.space/testnet/api/v1/ws, unused for Esplora
+	WebsocketURL string
+	Flavor       string
+	// Headers are sent on every request, for instances sitting behind an authenticating proxy
+	Headers map[string]string
+	// CAFile is a PEM bundle to trust in addition to the system roots, for instances using a private CA
+	CAFile string
+	// PollInterval is how often Esplora is asked for a new tip
+	PollInterval time.Duration
+}
+
+// MempoolSpace is the ChainBackend backed by the mempool.space (or a self-hosted mempool/Esplora) REST api and websocket
+type MempoolSpace struct {
+	instances map[string]Instance
+	clients   map[string]*http.Client
+	dialers   map[string]*websocket.Dialer
+}
+
+// networkPath is the prefix mempool.space puts in front of the pages and api of networks other than mainnet
+func networkPath(network string) string {
+	if network != "mainnet" && network != "" {
+		return "/" + strings.ToLower(network)
+	}
+	return ""
+}
+
+// ExplorerURL returns the base of the instance's web pages, its RestURL without the /api, ie
+// https://mempool.space/testnet
+func (i Instance) ExplorerURL() string {
+	return strings.TrimSuffix(strings.TrimSuffix(i.RestURL, "/"), "/api")
+}
+
+// Explorer returns the base of the network's pages on the mempool deployment at base, ie https://mempool.space/testnet
+func Explorer(base string, network string) string {
+	return strings.TrimSuffix(base, "/") + networkPath(network)
+}
+
+// DefaultInstance returns the public mempool.space instance for the network
+func DefaultInstance(network string) Instance {
+	path := networkPath(network)
+	return Instance{
+		RestURL:      fmt.Sprintf("https://mempool.space%s/api", path),
+		WebsocketURL: fmt.Sprintf("wss://mempool.space%s/api/v1/ws", path),
+		Flavor:       FlavorMempool,
+	}
+}
+
+// NewMempoolSpace builds the backend, any network without an entry in instances uses the public mempool.space
+func NewMempoolSpace(instances map[string]Instance) (*MempoolSpace, error) {
+	m := &MempoolSpace{
+		instances: make(map[string]Instance),
+		clients:   make(map[string]*http.Client),
+		dialers:   make(map[string]*websocket.Dialer),
+	}
+	for network, instance := range instances {
+		instance.RestURL = strings.TrimSuffix(instance.RestURL, "/")
+		if instance.Flavor == "" {
+			instance.Flavor = FlavorMempool
+		}
+		if instance.WebsocketURL == "" && instance.Flavor == FlavorMempool {
+			//mempool serves the websocket next to the REST api
+			wsUrl, err := websocketURL(instance.RestURL)
+			if err != nil {
+				return nil, fmt.Errorf("failed to derive the websocket url for %s: %w", network, err)
+			}
+			instance.WebsocketURL = wsUrl
+		}
+		if instance.PollInterval == 0 {
+			instance.PollInterval = time.Second * 30
+		}
+		tlsConfig, err := loadTLSConfig(instance.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load CA for %s: %w", network, err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		m.clients[network] = &http.Client{Transport: transport, Timeout: time.Second * 30}
+		m.dialers[network] = &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: time.Second * 45,
+			TLSClientConfig:  tlsConfig,
+		}
+		m.instances[network] = instance
+	}
+	return m, nil
+}
+
+// websocketURL swaps the scheme of the REST api for the websocket one, https becomes wss
+func websocketURL(restUrl string) (string, error) {
+	parsed, err := url.Parse(restUrl)
+	if err != nil {
+		return "", err
+	}
+	switch parsed.Scheme {
+	case "http":
+		parsed.Scheme = "ws"
+	case "https":
+		parsed.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("unsupported scheme %q in %s", parsed.Scheme, restUrl)
+	}
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/") + "/v1/ws"
+	return parsed.String(), nil
+}
+
+func loadTLSConfig(caFile string) (*tls.Config, error) {
+	if caFile == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+func (m *MempoolSpace) instance(network string) Instance {
+	if network == "" {
+		network = "mainnet"
+	}
+	instance, ok := m.instances[network]
+	if !ok {
+		return DefaultInstance(network)
+	}
+	return instance
+}
+
+func (m *MempoolSpace) client(network string) *http.Client {
+	if network == "" {
+		network = "mainnet"
+	}
+	client, ok := m.clients[network]
+	if !ok {
+		return http.DefaultClient
+	}
+	return client
+}
+
+func (m *MempoolSpace) dialer(network string) *websocket.Dialer {
+	if network == "" {
+		network = "mainnet"
+	}
+	dialer, ok := m.dialers[network]
+	if !ok {
+		return websocket.DefaultDialer
+	}
+	return dialer
+}
+
+func (m *MempoolSpace) headers(network string) http.Header {
+	header := http.Header{}
+	for key, value := range m.instance(network).Headers {
+		header.Set(key, value)
+	}
+	return header
+}
+
+// get requests a path of the network's REST api and returns the raw body
+func (m *MempoolSpace) get(network string, path string) ([]byte, error) {
+	mempoolSpaceUrl := m.instance(network).RestURL + path
+	req, err := http.NewRequest(http.MethodGet, mempoolSpaceUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = m.headers(network)
+	resp, err := m.client(network).Do(req)
+	if err != nil {
+		log.Printf("failed to request out to mempool.space")
+		return nil, err
+	}
+	if resp.Body != nil {
+		defer resp.Body.Close()
+	}
+	body, readErr := io.ReadAll(resp.Body)
+	if readErr != nil {
+		log.Printf("failed to read body from response of mempool.space")
+		return nil, readErr
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Url: mempoolSpaceUrl, StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	return body, nil
+}
+
+// StatusError is returned when the REST api answers with anything other than 200
+type StatusError struct {
+	Url        string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s returned %d: %s", e.Url, e.StatusCode, e.Body)
+}
+
+const (
+	minReconnectBackoff = time.Second * 5
+	maxReconnectBackoff = time.Minute * 5
+)
+
+var closeByApi = regexp.MustCompile(`(close 1006 \(abnormal closure\))`)
+
+func (m *MempoolSpace) ListenForBlocks(newBlock chan models.NewBlock, network string, mempoolSpaceCtx context.Context) {
+	if m.instance(network).Flavor == FlavorEsplora {
+		m.pollForBlocks(newBlock, network, mempoolSpaceCtx)
+		return
+	}
+	backoff := minReconnectBackoff
+	for {
+		conn, err := m.SetupClient(network, mempoolSpaceCtx)
+		if err == nil {
+			backoff = minReconnectBackoff
+			err = m.readBlocks(conn, newBlock, network)
+			conn.Close()
+		}
+		select {
+		case <-mempoolSpaceCtx.Done():
+			log.Printf("Shutting down mempool.space websocket listener for %s", network)
+			return
+		case <-time.After(backoff):
+			log.Printf("mempool.space websocket lost (%v), re-creating and continuing to listen", err)
+		}
+		//back off while the instance keeps refusing connections
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// readBlocks forwards the blocks event from the websocket until the connection drops
+func (m *MempoolSpace) readBlocks(conn *websocket.Conn, newBlock chan models.NewBlock, network string) error {
+	eventNetwork := network
+	if eventNetwork == "mainnet" {
+		eventNetwork = ""
+	}
+	log.Printf("listening to the blocks event from mempool.space")
+	for {
+		_, message, errRead := conn.ReadMessage()
+		if errRead != nil {
+			if closeByApi.MatchString(errRead.Error()) {
+				log.Printf("api killed the connection")
+			} else {
+				log.Printf("error while reading message from mempool.space: %s", errRead.Error())
+			}
+			return errRead
+		}
+		var objmap map[string]json.RawMessage
+		err := json.Unmarshal(message, &objmap)
+		if err != nil {
+			log.Printf("\nerror while unmarshalling top level block object %s", err.Error())
+			continue
+		}
+		var block models.Block
+		errBlock := json.Unmarshal(objmap["block"], &block)
+		if errBlock != nil {
+			log.Printf("\nerror while unmarshalling block object %s", errBlock.Error())
+			continue
+		}
+		log.Printf("\nnew block message %s", message)
+		newBlock <- models.NewBlock{IsNew: true, Network: eventNetwork, BlockHeight: block.Height, BlockHash: block.Id, PreviousBlockHash: block.Previousblockhash}
+	}
+}
+
+// pollForBlocks watches the tip hash of instances without a websocket, ie Esplora
+func (m *MempoolSpace) pollForBlocks(newBlock chan models.NewBlock, network string, ctx context.Context) {
+	eventNetwork := network
+	if eventNetwork == "mainnet" {
+		eventNetwork = ""
+	}
+	interval := m.instance(network).PollInterval
+	log.Printf("polling %s every %s for new blocks", m.instance(network).RestURL, interval)
+	lastHash := ""
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		tipHash, err := m.get(network, "/blocks/tip/hash")
+		if err != nil {
+			log.Printf("error while polling for the tip hash: %s", err.Error())
+		} else if string(tipHash) != lastHash {
+			first := lastHash == ""
+			lastHash = string(tipHash)
+			if !first {
+				block, errBlock := m.GetBlock(lastHash, network)
+				if errBlock != nil {
+					log.Printf("error while looking up the new tip: %s", errBlock.Error())
+				} else {
+					log.Printf("\nnew block %s", lastHash)
+					newBlock <- models.NewBlock{IsNew: true, Network: eventNetwork, BlockHeight: block.Height, BlockHash: block.Id, PreviousBlockHash: block.Previousblockhash}
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("Shutting down block polling for %s", network)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *MempoolSpace) SetupClient(network string, mempoolSpaceCtx context.Context) (*websocket.Conn, error) {
+	urlStr := m.instance(network).WebsocketURL
+	log.Printf("\nconnecting to mempool.space websocket: %s", urlStr)
+
+	conn, _, err := m.dialer(network).DialContext(mempoolSpaceCtx, urlStr, m.headers(network))
+	if err != nil {
+		log.Printf("error connecting to mempool.space websocket %s", err.Error())
+		return nil, err
+	}
+	errWrite := conn.WriteJSON(models.MempoolListen{Action: "want", Data: []string{"blocks"}})
+	if errWrite != nil {
+		log.Printf("error setting up listen for 'blocks' %s", errWrite.Error())
+		return nil, errWrite
+	}
+	log.Println("setting up listen for 'blocks' from mempool.space websocket")
+	KeepAlive(conn, time.Second*120)
+
+	return conn, nil
+}
+
+func KeepAlive(c *websocket.Conn, timeout time.Duration) {
+	lastResponse := time.Now()
+	c.SetPongHandler(func(msg string) error {
+		lastResponse = time.Now()
+		return nil
+	})
+
+	go func() {
+		for {
+			err := c.WriteMessage(websocket.PingMessage, []byte("keepalive"))
+			if err != nil {
+				return
+			}
+			log.Println("Websocket ping message sent to mempool.space")
+			time.Sleep(timeout / 2)
+			if time.Since(lastResponse) > timeout {
+				c.Close()
+				return
+			}
+		}
+	}()
+}
+
+func (m *MempoolSpace) CheckTransactionWasConfirmed(txId string, network string) (*models.ConfirmedPayload, error) {
+	body, err := m.get(network, fmt.Sprintf("/tx/%s/status", txId))
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("\npayload %s", body)
+	confirmed := &models.ConfirmedPayload{}
+	errMarshal := json.Unmarshal(body, confirmed)
+	if errMarshal != nil {
+		log.Printf("failed to unmarshal body from response of mempool.space")
+		return nil, errMarshal
+	}
+	return confirmed, nil
+}
+
+// GetBlock returns the block, along with its extras such as the mining pool when the instance is mempool
+func (m *MempoolSpace) GetBlock(blockHash string, network string) (*models.Block, error) {
+	path := fmt.Sprintf("/block/%s", blockHash)
+	if m.instance(network).Flavor == FlavorMempool {
+		//only the v1 api of mempool has the extras
+		path = "/v1" + path
+	}
+	body, err := m.get(network, path)
+	if err != nil {
+		return nil, err
+	}
+	block := &models.Block{}
+	errMarshal := json.Unmarshal(body, block)
+	if errMarshal != nil {
+		log.Printf("failed to unmarshal body from response of mempool.space")
+		return nil, errMarshal
+	}
+	return block, nil
+}
+
+func (m *MempoolSpace) GetLastBlockHeight(network string) (*int, error) {
+	heightRaw, err := m.get(network, "/blocks/tip/height")
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("\nLast Block Height %s", heightRaw)
+	//the tip is returned as a plain decimal string
+	height, errConv := strconv.Atoi(strings.TrimSpace(string(heightRaw)))
+	if errConv != nil {
+		log.Printf("failed to parse block height from response of mempool.space")
+		return nil, errConv
+	}
+
+	return &height, nil
+}
+
+type txPayload struct {
+	TxID   string                  `json:"txid"`
+	Fee    int64                   `json:"fee"`
+	Weight int                     `json:"weight"`
+	Vin    []txInput               `json:"vin"`
+	Status models.ConfirmedPayload `json:"status"`
+}
+
+type txInput struct {
+	TxID string `json:"txid"`
+	Vout int    `json:"vout"`
+}
+
+func (m *MempoolSpace) GetTransaction(txId string, network string) (*models.TxInfo, error) {
+	body, err := m.get(network, fmt.Sprintf("/tx/%s", txId))
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return nil, models.ErrTxNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	payload := txPayload{}
+	errMarshal := json.Unmarshal(body, &payload)
+	if errMarshal != nil {
+		log.Printf("failed to unmarshal body from response of mempool.space")
+		return nil, errMarshal
+	}
+	info := &models.TxInfo{
+		TxID:      payload.TxID,
+		Fee:       payload.Fee,
+		VSize:     (payload.Weight + 3) / 4,
+		Confirmed: payload.Status.Confirmed,
+	}
+	for _, in := range payload.Vin {
+		info.Inputs = append(info.Inputs, models.Outpoint{TxID: in.TxID, Vout: in.Vout})
+	}
+	return info, nil
+}
+
+type rbfPayload struct {
+	Replacements *rbfNode `json:"replacements"`
+}
+
+type rbfNode struct {
+	Tx struct {
+		TxID string `json:"txid"`
+	} `json:"tx"`
+}
+
+type outspendPayload struct {
+	Spent bool   `json:"spent"`
+	TxID  string `json:"txid"`
+}
+
+func (m *MempoolSpace) GetReplacement(txId string, network string, inputs []models.Outpoint) (string, error) {
+	//mempool keeps the replacement history, Esplora only has outspends
+	if m.instance(network).Flavor == FlavorMempool {
+		body, err := m.get(network, fmt.Sprintf("/v1/tx/%s/rbf", txId))
+		if err != nil {
+			log.Printf("failed to look up rbf history of %s: %s", txId, err.Error())
+		} else {
+			rbf := rbfPayload{}
+			errMarshal := json.Unmarshal(body, &rbf)
+			if errMarshal == nil && rbf.Replacements != nil && rbf.Replacements.Tx.TxID != txId {
+				return rbf.Replacements.Tx.TxID, nil
+			}
+		}
+	}
+	for _, input := range inputs {
+		body, err := m.get(network, fmt.Sprintf("/tx/%s/outspend/%d", input.TxID, input.Vout))
+		if err != nil {
+			return "", err
+		}
+		outspend := outspendPayload{}
+		errMarshal := json.Unmarshal(body, &outspend)
+		if errMarshal != nil {
+			log.Printf("failed to unmarshal body from response of mempool.space")
+			return "", errMarshal
+		}
+		if outspend.Spent && outspend.TxID != txId {
+			return outspend.TxID, nil
+		}
+	}
+	return "", nil
+}
+
+type addressTxPayload struct {
+	TxID   string                  `json:"txid"`
+	Vout   []addressTxOutput       `json:"vout"`
+	Status models.ConfirmedPayload `json:"status"`
+}
+
+type addressTxOutput struct {
+	ScriptPubkeyAddress string `json:"scriptpubkey_address"`
+	Value               int64  `json:"value"`
+}
+
+func (m *MempoolSpace) GetAddressTransactions(address string, network string) ([]models.AddressTx, error) {
+	body, err := m.get(network, fmt.Sprintf("/address/%s/txs", address))
+	if err != nil {
+		return nil, err
+	}
+	var payload []addressTxPayload
+	errMarshal := json.Unmarshal(body, &payload)
+	if errMarshal != nil {
+		log.Printf("failed to unmarshal body from response of mempool.space")
+		return nil, errMarshal
+	}
+	addressTxs := []models.AddressTx{}
+	for _, tx := range payload {
+		addressTx := models.AddressTx{TxID: tx.TxID, Confirmed: tx.Status.Confirmed}
+		if tx.Status.BlockHeight != nil {
+			addressTx.BlockHeight = *tx.Status.BlockHeight
+		}
+		for _, out := range tx.Vout {
+			if out.ScriptPubkeyAddress == address {
+				addressTx.Amount += out.Value
+			}
+		}
+		//txs only spending from the address are in the history too
+		if addressTx.Amount > 0 {
+			addressTxs = append(addressTxs, addressTx)
+		}
+	}
+	return addressTxs, nil
+}
@@ -0,0 +1,89 @@
+package mempool
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	"tx-tracker/pkg/models"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebsocketURL(t *testing.T) {
+	tests := []struct {
+		restUrl string
+		want    string
+		wantErr bool
+	}{
+		{restUrl: "https://mempool.space/api", want: "wss://mempool.space/api/v1/ws"},
+		{restUrl: "http://127.0.0.1:8999/api/", want: "ws://127.0.0.1:8999/api/v1/ws"},
+		//only the scheme changes, not an http further along the url
+		{restUrl: "https://http.example/mempool-http/api", want: "wss://http.example/mempool-http/api/v1/ws"},
+		{restUrl: "ftp://mempool.space/api", wantErr: true},
+		{restUrl: "mempool.space/api", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := websocketURL(test.restUrl)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("websocketURL(%q) = %q, want an error", test.restUrl, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("websocketURL(%q) = %q, %v, want %q", test.restUrl, got, err, test.want)
+		}
+	}
+}
+
+// a refused first connection is retried rather than leaving the network without blocks
+func TestListenForBlocksRetriesSetupClient(t *testing.T) {
+	var mutex sync.Mutex
+	attempts := 0
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		attempts++
+		attempt := attempts
+		mutex.Unlock()
+		if attempt == 1 {
+			http.Error(w, "starting up", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade: %s", err)
+			return
+		}
+		defer conn.Close()
+		want := models.MempoolListen{}
+		if err := conn.ReadJSON(&want); err != nil || want.Action != "want" {
+			t.Errorf("got %+v, %v, want the blocks subscription", want, err)
+		}
+		conn.WriteJSON(map[string]interface{}{"block": models.Block{Id: "bb", Height: 800001, Previousblockhash: "aa"}})
+		//hold the connection open until the test is done with it
+		conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	backend, err := NewMempoolSpace(map[string]Instance{"mainnet": {RestURL: server.URL + "/api"}})
+	if err != nil {
+		t.Fatalf("NewMempoolSpace failed: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	newBlock := make(chan models.NewBlock)
+	go backend.ListenForBlocks(newBlock, "mainnet", ctx)
+
+	select {
+	case block := <-newBlock:
+		if block.Network != "" || block.BlockHeight != 800001 || block.BlockHash != "bb" || block.PreviousBlockHash != "aa" {
+			t.Errorf("got %+v", block)
+		}
+	case <-time.After(minReconnectBackoff * 3):
+		t.Fatalf("no block after the first connection was refused")
+	}
+}
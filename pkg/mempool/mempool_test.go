@@ -0,0 +1,417 @@
+package mempool
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+	"tx-tracker/pkg/models"
+	"tx-tracker/pkg/utils"
+)
+
+// fakeChain is a ChainBackend answering from a fixed view of the chain, counting the status lookups of each txid
+type fakeChain struct {
+	mutex     sync.Mutex
+	tips      map[string]int
+	confirmed map[string]models.ConfirmedPayload
+	lookups   map[string]int
+}
+
+func newFakeChain(tip int) *fakeChain {
+	return &fakeChain{
+		tips:      map[string]int{"mainnet": tip},
+		confirmed: make(map[string]models.ConfirmedPayload),
+		lookups:   make(map[string]int),
+	}
+}
+
+// confirm puts the transaction in the block with the hash at height
+func (c *fakeChain) confirm(txId string, height int, hash string) {
+	blockTime := 1690000000 + height
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.confirmed[txId] = models.ConfirmedPayload{Confirmed: true, BlockHeight: &height, BlockHash: &hash, BlockTime: &blockTime}
+}
+
+func (c *fakeChain) GetLastBlockHeight(network string) (*int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	tip, ok := c.tips[utils.NormalizeNetwork(network)]
+	if !ok {
+		return nil, fmt.Errorf("no tip for %s", network)
+	}
+	return &tip, nil
+}
+
+func (c *fakeChain) CheckTransactionWasConfirmed(txId string, network string) (*models.ConfirmedPayload, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lookups[txId]++
+	status := c.confirmed[txId]
+	return &status, nil
+}
+
+func (c *fakeChain) GetTransaction(txId string, network string) (*models.TxInfo, error) {
+	return &models.TxInfo{TxID: txId, Fee: 1410, VSize: 141, Confirmed: true}, nil
+}
+
+func (c *fakeChain) ListenForBlocks(newBlock chan models.NewBlock, network string, ctx context.Context) {
+	<-ctx.Done()
+}
+
+// recorder is a Notifier keeping a line for each message, they are sent from their own goroutines so arrive in any order
+type recorder struct {
+	messages chan string
+}
+
+func newRecorder() *recorder {
+	return &recorder{messages: make(chan string, 100)}
+}
+
+func (r *recorder) SendErrorMessage(watchTx models.WatchTx, text string) {
+	r.messages <- fmt.Sprintf("error %s", watchTx.ID)
+}
+
+func (r *recorder) SendMempoolMessage(watchTx models.WatchTx) {
+	r.messages <- fmt.Sprintf("mempool %s", watchTx.ID)
+}
+
+func (r *recorder) SendDroppedMessage(watchTx models.WatchTx) {
+	r.messages <- fmt.Sprintf("dropped %s", watchTx.ID)
+}
+
+func (r *recorder) SendReplacedMessage(watchTx models.WatchTx) {
+	r.messages <- fmt.Sprintf("replaced %s", watchTx.ID)
+}
+
+func (r *recorder) SendReorgMessage(watchTx models.WatchTx, lostConfs int) {
+	r.messages <- fmt.Sprintf("reorg %s lost %d now %d", watchTx.ID, lostConfs, watchTx.ConfsCount)
+}
+
+func (r *recorder) SendFirstConfMessage(watchTx models.WatchTx, confirmed models.ConfirmedPayload) {
+	r.messages <- fmt.Sprintf("first %s %d", watchTx.ID, watchTx.ConfsCount)
+}
+
+func (r *recorder) SendUpdatedConfMessage(watchTx models.WatchTx) {
+	r.messages <- fmt.Sprintf("updated %s %d", watchTx.ID, watchTx.ConfsCount)
+}
+
+func (r *recorder) SendFinalMessage(watchTx models.WatchTx) {
+	r.messages <- fmt.Sprintf("final %s %d", watchTx.ID, watchTx.ConfsCount)
+}
+
+// expect checks exactly the wanted messages were sent, in any order
+func (r *recorder) expect(t *testing.T, want ...string) {
+	t.Helper()
+	got := []string{}
+	timeout := time.After(time.Second)
+	for len(got) < len(want) {
+		select {
+		case message := <-r.messages:
+			got = append(got, message)
+		case <-timeout:
+			t.Fatalf("got messages %q, want %q", got, want)
+		}
+	}
+	//give any unwanted message a moment to show up
+	select {
+	case message := <-r.messages:
+		got = append(got, message)
+	case <-time.After(time.Millisecond * 50):
+	}
+	sort.Strings(got)
+	sorted := append([]string{}, want...)
+	sort.Strings(sorted)
+	if !reflect.DeepEqual(got, sorted) {
+		t.Errorf("got messages %q, want %q", got, sorted)
+	}
+}
+
+// confirmedWatch is a watch on txId from the channel that counted confsCount confirmations in the block with the hash
+func confirmedWatch(txId string, channel string, confs int, confsCount int, height int, hash string) models.WatchTx {
+	return models.WatchTx{
+		TxID:               txId,
+		Channel:            channel,
+		Confs:              confs,
+		ConfsCount:         confsCount,
+		ConfirmBlockHeight: height,
+		ConfirmBlockHash:   hash,
+		State:              models.StateConfirmed,
+		TimeRequested:      time.Now().UTC().Unix(),
+		VSize:              141,
+	}
+}
+
+func TestCheckForReorg(t *testing.T) {
+	tests := []struct {
+		name string
+		// the block holding the transaction on the new chain, empty while it's back in the mempool
+		newHash      string
+		newHeight    int
+		wantConfs    int
+		wantHeight   int
+		wantState    string
+		wantMessages []string
+	}{
+		{
+			name:         "back in the mempool",
+			wantConfs:    0,
+			wantHeight:   0,
+			wantState:    models.StateWatching,
+			wantMessages: []string{"reorg mainnet:aa:C1 lost 3 now 0"},
+		},
+		{
+			name:         "confirmed again a block later",
+			newHash:      "b2",
+			newHeight:    801,
+			wantConfs:    2,
+			wantHeight:   801,
+			wantState:    models.StateConfirmed,
+			wantMessages: []string{"reorg mainnet:aa:C1 lost 1 now 2"},
+		},
+		{
+			//the confirming block changed but it's still as deep, nothing was lost
+			name:       "confirmed again at the same height",
+			newHash:    "b1",
+			newHeight:  800,
+			wantConfs:  3,
+			wantHeight: 800,
+			wantState:  models.StateConfirmed,
+		},
+		{
+			name:       "block still on the best chain",
+			newHash:    "a1",
+			newHeight:  800,
+			wantConfs:  3,
+			wantHeight: 800,
+			wantState:  models.StateConfirmed,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain := newFakeChain(802)
+			if len(test.newHash) > 0 {
+				chain.confirm("aa", test.newHeight, test.newHash)
+			}
+			store := utils.NewWatchStore()
+			watchTx, _ := store.Add(confirmedWatch("aa", "C1", 6, 3, 800, "a1"))
+			notifier := newRecorder()
+
+			CheckForReorg(chain, store, "", 802, notifier)
+
+			stored, ok := store.Get(watchTx.ID)
+			if !ok {
+				t.Fatalf("the watch was removed")
+			}
+			if stored.ConfsCount != test.wantConfs || stored.ConfirmBlockHeight != test.wantHeight || stored.State != test.wantState {
+				t.Errorf("got %d confirmations at %d in state %q, want %d at %d in %q", stored.ConfsCount, stored.ConfirmBlockHeight, stored.State, test.wantConfs, test.wantHeight, test.wantState)
+			}
+			if len(test.newHash) > 0 && stored.ConfirmBlockHash != test.newHash {
+				t.Errorf("got confirming block %s, want %s", stored.ConfirmBlockHash, test.newHash)
+			}
+			notifier.expect(t, test.wantMessages...)
+		})
+	}
+}
+
+// watches that haven't confirmed have nothing to roll back, the chain isn't asked about them
+func TestCheckForReorgSkipsUnconfirmed(t *testing.T) {
+	chain := newFakeChain(802)
+	store := utils.NewWatchStore()
+	store.Add(models.WatchTx{TxID: "aa", Channel: "C1", Confs: 6, TimeRequested: time.Now().UTC().Unix()})
+	notifier := newRecorder()
+
+	CheckForReorg(chain, store, "mainnet", 802, notifier)
+
+	if chain.lookups["aa"] != 0 {
+		t.Errorf("looked up an unconfirmed transaction %d times", chain.lookups["aa"])
+	}
+	notifier.expect(t)
+}
+
+// a reorg while the bot was down is caught on startup, against the tip of each network with watches
+func TestCheckNetworksForReorg(t *testing.T) {
+	chain := newFakeChain(805)
+	chain.tips["testnet"] = 2500000
+	chain.confirm("bb", 2499998, "t1")
+	store := utils.NewWatchStore()
+	mainnet, _ := store.Add(confirmedWatch("aa", "C1", 10, 4, 800, "a1"))
+	testnetWatch := confirmedWatch("bb", "C1", 6, 3, 2499998, "t1")
+	testnetWatch.Network = "testnet"
+	testnet, _ := store.Add(testnetWatch)
+	notifier := newRecorder()
+
+	CheckNetworksForReorg(chain, store, notifier)
+
+	//aa was reorged out while the bot was down
+	if stored, _ := store.Get(mainnet.ID); stored.ConfsCount != 0 || stored.State != models.StateWatching {
+		t.Errorf("got %d confirmations in state %q, want the reorged watch rolled back", stored.ConfsCount, stored.State)
+	}
+	if stored, _ := store.Get(testnet.ID); stored.ConfsCount != 3 || stored.ConfirmBlockHash != "t1" {
+		t.Errorf("got %+v, want the testnet watch untouched", stored)
+	}
+	notifier.expect(t, "reorg mainnet:aa:C1 lost 4 now 0")
+}
+
+func TestListenForUserTransChecksForReorgOnStartup(t *testing.T) {
+	chain := newFakeChain(805)
+	store := utils.NewWatchStore()
+	store.Add(confirmedWatch("aa", "C1", 10, 4, 800, "a1"))
+	notifier := newRecorder()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ListenForUserTrans(chain, store, make(chan models.WatchTx), make(chan models.NewBlock), notifier, ctx)
+
+	notifier.expect(t, "reorg mainnet:aa:C1 lost 4 now 0")
+}
+
+func TestConfirmations(t *testing.T) {
+	tests := []struct {
+		confirmHeight int
+		tipHeight     int
+		want          int
+	}{
+		{confirmHeight: 800, tipHeight: 800, want: 1},
+		{confirmHeight: 800, tipHeight: 805, want: 6},
+		//the tip event hasn't arrived yet
+		{confirmHeight: 800, tipHeight: 799, want: 0},
+		{confirmHeight: 0, tipHeight: 800, want: 0},
+	}
+	for _, test := range tests {
+		if got := Confirmations(test.confirmHeight, test.tipHeight); got != test.want {
+			t.Errorf("Confirmations(%d, %d) = %d, want %d", test.confirmHeight, test.tipHeight, got, test.want)
+		}
+	}
+}
+
+func TestSendMessageForWatched(t *testing.T) {
+	tests := []struct {
+		name string
+		// watchTx is the stored watch on aa with a target of 6, aa is confirmed in block 800
+		watchTx      models.WatchTx
+		tip          int
+		wantConfs    int
+		wantRemoved  bool
+		wantMessages []string
+	}{
+		{
+			name:         "first confirmation",
+			watchTx:      models.WatchTx{TxID: "aa", Channel: "C1", Confs: 6},
+			tip:          800,
+			wantConfs:    1,
+			wantMessages: []string{"first mainnet:aa:C1 1"},
+		},
+		{
+			//confirmed while the bot was down, it's picked up with every confirmation since
+			name:         "first seen a few blocks deep",
+			watchTx:      models.WatchTx{TxID: "aa", Channel: "C1", Confs: 6},
+			tip:          802,
+			wantConfs:    3,
+			wantMessages: []string{"first mainnet:aa:C1 3"},
+		},
+		{
+			name:         "next block",
+			watchTx:      confirmedWatch("aa", "C1", 6, 1, 800, "a1"),
+			tip:          801,
+			wantConfs:    2,
+			wantMessages: []string{"updated mainnet:aa:C1 2"},
+		},
+		{
+			//the events of 801 to 803 were missed, they're reported in the one message
+			name:         "missed blocks collapsed",
+			watchTx:      confirmedWatch("aa", "C1", 6, 1, 800, "a1"),
+			tip:          804,
+			wantConfs:    5,
+			wantMessages: []string{"updated mainnet:aa:C1 5"},
+		},
+		{
+			name:      "same block again",
+			watchTx:   confirmedWatch("aa", "C1", 6, 2, 800, "a1"),
+			tip:       801,
+			wantConfs: 2,
+		},
+		{
+			name:         "target reached past missed blocks",
+			watchTx:      confirmedWatch("aa", "C1", 6, 2, 800, "a1"),
+			tip:          810,
+			wantRemoved:  true,
+			wantMessages: []string{"final mainnet:aa:C1 11"},
+		},
+		{
+			name:      "not confirmed yet",
+			watchTx:   models.WatchTx{TxID: "bb", Channel: "C1", Confs: 6},
+			tip:       800,
+			wantConfs: 0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain := newFakeChain(test.tip)
+			chain.confirm("aa", 800, "a1")
+			store := utils.NewWatchStore()
+			test.watchTx.TimeRequested = time.Now().UTC().Unix()
+			watchTx, _ := store.Add(test.watchTx)
+			notifier := newRecorder()
+
+			SendMessageForWatched(chain, store, "mainnet", test.tip, notifier)
+
+			stored, ok := store.Get(watchTx.ID)
+			if ok == test.wantRemoved {
+				t.Fatalf("got stored %v, want removed %v", ok, test.wantRemoved)
+			}
+			if ok && stored.ConfsCount != test.wantConfs {
+				t.Errorf("got %d confirmations, want %d", stored.ConfsCount, test.wantConfs)
+			}
+			notifier.expect(t, test.wantMessages...)
+		})
+	}
+}
+
+// the fee of a transaction first seen confirmed is looked up once, along with the block it's in
+func TestSendMessageForWatchedFillsInFee(t *testing.T) {
+	chain := newFakeChain(800)
+	chain.confirm("aa", 800, "a1")
+	store := utils.NewWatchStore()
+	watchTx, _ := store.Add(models.WatchTx{TxID: "aa", Channel: "C1", Confs: 6, TimeRequested: time.Now().UTC().Unix()})
+	notifier := newRecorder()
+
+	SendMessageForWatched(chain, store, "", 800, notifier)
+
+	stored, _ := store.Get(watchTx.ID)
+	if stored.ConfirmBlockHash != "a1" || stored.ConfirmBlockTime != 1690000800 || stored.Fee != 1410 || stored.VSize != 141 {
+		t.Errorf("got %+v", stored)
+	}
+	notifier.expect(t, "first mainnet:aa:C1 1")
+}
+
+// several channels watching a transaction share one status lookup, each is advanced from its own count
+func TestSendMessageForWatchedLooksUpEachTxOnce(t *testing.T) {
+	chain := newFakeChain(801)
+	chain.confirm("aa", 800, "a1")
+	store := utils.NewWatchStore()
+	now := time.Now().UTC().Unix()
+	store.Add(models.WatchTx{TxID: "aa", Channel: "C1", Confs: 6, TimeRequested: now})
+	store.Add(models.WatchTx{TxID: "aa", Channel: "C2", Confs: 2, TimeRequested: now})
+	store.Add(models.WatchTx{TxID: "aa", Channel: "!room", Frontend: models.FrontendMatrix, Confs: 6, TimeRequested: now})
+	store.Add(models.WatchTx{TxID: "bb", Channel: "C1", Confs: 6, TimeRequested: now})
+	notifier := newRecorder()
+
+	SendMessageForWatched(chain, store, "mainnet", 801, notifier)
+
+	if chain.lookups["aa"] != 1 || chain.lookups["bb"] != 1 {
+		t.Errorf("got %v status lookups, want one for each txid", chain.lookups)
+	}
+	notifier.expect(t, "first mainnet:aa:C1 2", "final mainnet:aa:C2 2", "first mainnet:aa:matrix/!room 2")
+
+	//once every watch knows its block the chain isn't asked again
+	chain.tips["mainnet"] = 802
+	SendMessageForWatched(chain, store, "mainnet", 802, notifier)
+	if chain.lookups["aa"] != 1 {
+		t.Errorf("got %d status lookups of aa, want it only looked up for the first block", chain.lookups["aa"])
+	}
+	notifier.expect(t, "updated mainnet:aa:C1 3", "updated mainnet:aa:matrix/!room 3")
+}
@@ -0,0 +1,116 @@
+package mempool
+
+import (
+	"log"
+	"tx-tracker/pkg/models"
+	"tx-tracker/pkg/utils"
+)
+
+// Notifier sends the messages about a watched transaction as its state changes
+type Notifier interface {
+	// SendErrorMessage tells the requester a watch couldn't be set up
+	SendErrorMessage(watchTx models.WatchTx, text string)
+	SendMempoolMessage(watchTx models.WatchTx)
+	SendDroppedMessage(watchTx models.WatchTx)
+	SendReplacedMessage(watchTx models.WatchTx)
+	SendReorgMessage(watchTx models.WatchTx, lostConfs int)
+	SendFirstConfMessage(watchTx models.WatchTx, confirmed models.ConfirmedPayload)
+	SendUpdatedConfMessage(watchTx models.WatchTx)
+	SendFinalMessage(watchTx models.WatchTx)
+}
+
+// Notifiers sends every message through each of its notifiers
+type Notifiers []Notifier
+
+func (n Notifiers) SendErrorMessage(watchTx models.WatchTx, text string) {
+	for _, notifier := range n {
+		notifier.SendErrorMessage(watchTx, text)
+	}
+}
+
+func (n Notifiers) SendMempoolMessage(watchTx models.WatchTx) {
+	for _, notifier := range n {
+		notifier.SendMempoolMessage(watchTx)
+	}
+}
+
+func (n Notifiers) SendDroppedMessage(watchTx models.WatchTx) {
+	for _, notifier := range n {
+		notifier.SendDroppedMessage(watchTx)
+	}
+}
+
+func (n Notifiers) SendReplacedMessage(watchTx models.WatchTx) {
+	for _, notifier := range n {
+		notifier.SendReplacedMessage(watchTx)
+	}
+}
+
+func (n Notifiers) SendReorgMessage(watchTx models.WatchTx, lostConfs int) {
+	for _, notifier := range n {
+		notifier.SendReorgMessage(watchTx, lostConfs)
+	}
+}
+
+func (n Notifiers) SendFirstConfMessage(watchTx models.WatchTx, confirmed models.ConfirmedPayload) {
+	for _, notifier := range n {
+		notifier.SendFirstConfMessage(watchTx, confirmed)
+	}
+}
+
+func (n Notifiers) SendUpdatedConfMessage(watchTx models.WatchTx) {
+	for _, notifier := range n {
+		notifier.SendUpdatedConfMessage(watchTx)
+	}
+}
+
+func (n Notifiers) SendFinalMessage(watchTx models.WatchTx) {
+	for _, notifier := range n {
+		notifier.SendFinalMessage(watchTx)
+	}
+}
+
+// FrontendNotifiers sends each message to the notifier of the frontend the watch was requested from
+type FrontendNotifiers map[string]Notifier
+
+func (n FrontendNotifiers) notifier(watchTx models.WatchTx) Notifier {
+	frontend := utils.NormalizeFrontend(watchTx.Frontend)
+	notifier, ok := n[frontend]
+	if !ok {
+		log.Printf("no notifier for frontend %s, dropping the message for %s", frontend, watchTx.ID)
+		return Notifiers{}
+	}
+	return notifier
+}
+
+func (n FrontendNotifiers) SendErrorMessage(watchTx models.WatchTx, text string) {
+	n.notifier(watchTx).SendErrorMessage(watchTx, text)
+}
+
+func (n FrontendNotifiers) SendMempoolMessage(watchTx models.WatchTx) {
+	n.notifier(watchTx).SendMempoolMessage(watchTx)
+}
+
+func (n FrontendNotifiers) SendDroppedMessage(watchTx models.WatchTx) {
+	n.notifier(watchTx).SendDroppedMessage(watchTx)
+}
+
+func (n FrontendNotifiers) SendReplacedMessage(watchTx models.WatchTx) {
+	n.notifier(watchTx).SendReplacedMessage(watchTx)
+}
+
+func (n FrontendNotifiers) SendReorgMessage(watchTx models.WatchTx, lostConfs int) {
+	n.notifier(watchTx).SendReorgMessage(watchTx, lostConfs)
+}
+
+func (n FrontendNotifiers) SendFirstConfMessage(watchTx models.WatchTx, confirmed models.ConfirmedPayload) {
+	n.notifier(watchTx).SendFirstConfMessage(watchTx, confirmed)
+}
+
+func (n FrontendNotifiers) SendUpdatedConfMessage(watchTx models.WatchTx) {
+	n.notifier(watchTx).SendUpdatedConfMessage(watchTx)
+}
+
+func (n FrontendNotifiers) SendFinalMessage(watchTx models.WatchTx) {
+	n.notifier(watchTx).SendFinalMessage(watchTx)
+}
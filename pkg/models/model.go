@@ -1,24 +1,119 @@
 package models
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrTxNotFound is returned by chain backends for transactions that are neither in the mempool nor confirmed
+var ErrTxNotFound = errors.New("transaction not found")
+
 type MempoolListen struct {
 	Action string   `json:"action"`
 	Data   []string `json:"data"`
 }
 
 type NewBlock struct {
-	IsNew       bool   `json:"is_new"`
-	Network     string `json:"network"`
-	BlockHeight int    `json:"block_height"`
+	IsNew             bool   `json:"is_new"`
+	Network           string `json:"network"`
+	BlockHeight       int    `json:"block_height"`
+	BlockHash         string `json:"block_hash"`
+	PreviousBlockHash string `json:"previous_block_hash"`
 }
 
+// lifecycle states of a watched transaction
+const (
+	// StateWatching is a transaction that hasn't been seen yet
+	StateWatching  = ""
+	StateInMempool = "mempool"
+	StateConfirmed = "confirmed"
+	// StateDropped is a transaction that was in the mempool and has since been evicted
+	StateDropped = "dropped"
+	// StateReplaced is a transaction whose inputs were spent by another transaction, see WatchTx.ReplacedBy
+	StateReplaced = "replaced"
+)
+
+// reasons a watch stops, kept with the watch in the history of the sqlite storage
+const (
+	FinishCompleted = "completed"
+	FinishExpired   = "expired"
+	FinishCancelled = "cancelled"
+	// FinishMoved is a watch replaced by one on the transaction that replaced it
+	FinishMoved = "moved"
+)
+
+// action ids of the buttons posted with notifications
+const (
+	// ActionMoveWatch moves a watch onto the transaction that replaced it, the button value is "<old txid>:<new txid>"
+	ActionMoveWatch = "move_watch"
+	// ActionStopWatch stops watching a transaction in the channel the button was clicked in, the value is the txid
+	ActionStopWatch = "stop_watch"
+	// ActionCancelWatch stops the watch with the ID in the value, from the app home of the user who asked for it
+	ActionCancelWatch = "cancel_watch"
+	// ActionExtendWatch pushes back the expiry of the watch with the ID in the value, by utils.WatchExtension
+	ActionExtendWatch = "extend_watch"
+)
+
+// chat frontends a watch can be requested from, watches saved before there was more than one have no frontend
+// and belong to slack
+const (
+	FrontendSlack    = "slack"
+	FrontendDiscord  = "discord"
+	FrontendTelegram = "telegram"
+	FrontendMatrix   = "matrix"
+)
+
 type WatchTx struct {
-	TxID               string `json:"txId"`
-	Confs              int    `json:"confs"`
-	Network            string `json:"network"`
-	Channel            string `json:"channel"`
+	// ID is stable for the life of the watch, see utils.WatchID
+	ID   string `json:"id"`
+	TxID string `json:"txId"`
+	// Address is set when the watch is for the first transaction paying an address, TxID stays empty until one is seen
+	Address string `json:"address"`
+	// WatchedFromHeight is the chain tip when an address watch was added, payments confirmed at or below it were
+	// made before the watch and are ignored
+	WatchedFromHeight int    `json:"watched_from_height"`
+	Amount            int64  `json:"amount"`
+	Confs             int    `json:"confs"`
+	Network           string `json:"network"`
+	Channel           string `json:"channel"`
+	// Frontend is the chat the watch was requested from and is notified on, Channel is a channel of that frontend
+	Frontend string `json:"frontend"`
+	// User is the slack user who asked for the watch, empty on the other frontends and for watches saved before it
+	// was recorded
+	User string `json:"user"`
+	// ThreadID is the message the watch was requested in when updates are posted as replies in its thread, and
+	// StatusMessageID the message in that thread edited as the watch progresses, both empty for channel posts
+	ThreadID        string `json:"thread_id"`
+	StatusMessageID string `json:"status_message_id"`
+	// Notify are the email addresses sent the first confirmation and final messages, joined by ","
+	Notify             string `json:"notify"`
 	ConfsCount         int    `json:"confs_count"`
 	ConfirmBlockHeight int    `json:"confirm_block_height"`
-	TimeRequested      int64  `json:"time_requested"`
+	// ConfirmBlockHash is the block the transaction was confirmed in, used to spot it being reorged out
+	ConfirmBlockHash string `json:"confirm_block_hash"`
+	// ConfirmBlockTime is the unix time of the confirming block, zero for watches saved before it was tracked
+	ConfirmBlockTime int64 `json:"confirm_block_time"`
+	// ConfirmBlockPool is the mining pool that found the confirming block, empty when the backend doesn't know
+	ConfirmBlockPool string `json:"confirm_block_pool"`
+	TimeRequested    int64  `json:"time_requested"`
+	// ExpiresAt is when a watch that has been extended is dropped, zero for two weeks after TimeRequested
+	ExpiresAt int64  `json:"expires_at"`
+	State     string `json:"state"`
+	// Fee in sats and VSize in vbytes, filled in once the transaction is seen
+	Fee   int64 `json:"fee"`
+	VSize int   `json:"vsize"`
+	// Inputs are the outpoints the transaction spends, joined by JoinOutpoints since the struct has to stay comparable
+	Inputs     string `json:"inputs"`
+	ReplacedBy string `json:"replaced_by"`
+}
+
+// FeeRate returns the fee rate of the transaction in sat/vB
+func (w WatchTx) FeeRate() float64 {
+	if w.VSize == 0 {
+		return 0
+	}
+	return float64(w.Fee) / float64(w.VSize)
 }
 
 type ConfirmedPayload struct {
@@ -28,6 +123,51 @@ type ConfirmedPayload struct {
 	BlockTime   *int    `json:"block_time"`
 }
 
+// AddressTx is a transaction paying an address, Amount is the total of its outputs to the address in sats
+type AddressTx struct {
+	TxID        string `json:"txid"`
+	Amount      int64  `json:"amount"`
+	Confirmed   bool   `json:"confirmed"`
+	BlockHeight int    `json:"block_height"`
+}
+
+// TxInfo is a transaction as known by the chain backend, either in the mempool or confirmed
+type TxInfo struct {
+	TxID      string     `json:"txid"`
+	Fee       int64      `json:"fee"`
+	VSize     int        `json:"vsize"`
+	Confirmed bool       `json:"confirmed"`
+	Inputs    []Outpoint `json:"inputs"`
+}
+
+type Outpoint struct {
+	TxID string `json:"txid"`
+	Vout int    `json:"vout"`
+}
+
+// JoinOutpoints flattens outpoints into the "txid:vout,txid:vout" form stored on WatchTx.Inputs
+func JoinOutpoints(outpoints []Outpoint) string {
+	joined := make([]string, len(outpoints))
+	for i, outpoint := range outpoints {
+		joined[i] = fmt.Sprintf("%s:%d", outpoint.TxID, outpoint.Vout)
+	}
+	return strings.Join(joined, ",")
+}
+
+// SplitOutpoints reverses JoinOutpoints, skipping anything malformed
+func SplitOutpoints(joined string) []Outpoint {
+	outpoints := []Outpoint{}
+	for _, raw := range strings.Split(joined, ",") {
+		outpoint := Outpoint{}
+		_, err := fmt.Sscanf(strings.Replace(raw, ":", " ", 1), "%s %d", &outpoint.TxID, &outpoint.Vout)
+		if err != nil {
+			continue
+		}
+		outpoints = append(outpoints, outpoint)
+	}
+	return outpoints
+}
+
 type Block struct {
 	Extras            *Extras `json:"extras"`
 	Id                string  `json:"id"`
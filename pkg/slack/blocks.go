@@ -0,0 +1,124 @@
+package slack
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"tx-tracker/pkg/mempool"
+	"tx-tracker/pkg/models"
+	"tx-tracker/pkg/utils"
+
+	"github.com/slack-go/slack"
+)
+
+// BlockTarget is the average time between blocks, used to estimate when the next confirmation lands
+const BlockTarget = 10 * time.Minute
+
+// Explorers are the base of the explorer pages linked to for each network, ie "https://mempool.example.com/testnet",
+// networks without one link to the public mempool.space
+type Explorers map[string]string
+
+// URL returns the explorer page at path for the network, ie "tx/<txid>" or "block/<hash>"
+func (e Explorers) URL(network string, path string) string {
+	network = utils.NormalizeNetwork(network)
+	base, ok := e[network]
+	if !ok {
+		base = mempool.DefaultInstance(network).ExplorerURL()
+	}
+	return fmt.Sprintf("%s/%s", strings.TrimSuffix(base, "/"), path)
+}
+
+// ProgressBar draws count of total confirmations as a bar, ie "▓▓░░░ 2/5"
+func ProgressBar(count int, total int) string {
+	width := total
+	if width > 10 {
+		width = 10
+	}
+	filled := 0
+	if total > 0 {
+		filled = count * width / total
+	}
+	if filled > width {
+		filled = width
+	}
+	return fmt.Sprintf("%s%s %d/%d", strings.Repeat("▓", filled), strings.Repeat("░", width-filled), count, total)
+}
+
+// EstimateText says when the watch's next confirmation is expected, empty once the watch has all it needs
+func EstimateText(watchTx models.WatchTx, now time.Time) string {
+	if watchTx.ConfsCount >= watchTx.Confs {
+		return ""
+	}
+	switch watchTx.State {
+	case models.StateConfirmed:
+		if watchTx.ConfirmBlockTime == 0 {
+			return fmt.Sprintf(":hourglass_flowing_sand: %d more confirmations to go, blocks average %d minutes", watchTx.Confs-watchTx.ConfsCount, int(BlockTarget.Minutes()))
+		}
+		next := time.Unix(watchTx.ConfirmBlockTime, 0).Add(BlockTarget * time.Duration(watchTx.ConfsCount))
+		if next.Before(now) {
+			return fmt.Sprintf(":hourglass_flowing_sand: The next confirmation is due any minute, %d more to go", watchTx.Confs-watchTx.ConfsCount)
+		}
+		return fmt.Sprintf(":hourglass_flowing_sand: The next confirmation is expected around <!date^%d^{time}|%s>, %d more to go", next.Unix(), next.UTC().Format("15:04 MST"), watchTx.Confs-watchTx.ConfsCount)
+	case models.StateInMempool:
+		return fmt.Sprintf(":hourglass_flowing_sand: Blocks average %d minutes, when it confirms depends on its fee rate against the rest of the mempool", int(BlockTarget.Minutes()))
+	}
+	return ""
+}
+
+// watchBlocks lays a notification out as its headline, the details of the watch, the estimate of the next
+// confirmation and then any buttons
+func watchBlocks(watchTx models.WatchTx, explorers Explorers, headline string, buttons ...slack.BlockElement) []slack.Block {
+	network := utils.NormalizeNetwork(watchTx.Network)
+	fields := []*slack.TextBlockObject{
+		markdown(fmt.Sprintf("*Network*\n%s", network)),
+		markdown(fmt.Sprintf("*Confirmations*\n%s", ProgressBar(watchTx.ConfsCount, watchTx.Confs))),
+	}
+	if len(watchTx.TxID) > 0 {
+		fields = append(fields, markdown(fmt.Sprintf("*Transaction*\n<%s|%s>", explorers.URL(network, "tx/"+watchTx.TxID), shortID(watchTx.TxID))))
+	} else {
+		fields = append(fields, markdown(fmt.Sprintf("*Address*\n<%s|%s>", explorers.URL(network, "address/"+watchTx.Address), watchTx.Address)))
+	}
+	if watchTx.VSize > 0 {
+		fields = append(fields, markdown(fmt.Sprintf("*Fee rate*\n%.1f sat/vB (%d vB)", watchTx.FeeRate(), watchTx.VSize)))
+	}
+	if watchTx.ConfirmBlockHeight > 0 && len(watchTx.ConfirmBlockHash) > 0 {
+		fields = append(fields, markdown(fmt.Sprintf("*Block*\n<%s|%d>", explorers.URL(network, "block/"+watchTx.ConfirmBlockHash), watchTx.ConfirmBlockHeight)))
+	}
+	if len(watchTx.ConfirmBlockPool) > 0 {
+		fields = append(fields, markdown(fmt.Sprintf("*Mined by*\n%s", watchTx.ConfirmBlockPool)))
+	}
+
+	blocks := []slack.Block{
+		slack.NewSectionBlock(markdown(headline), nil, nil),
+		slack.NewSectionBlock(nil, fields, nil),
+	}
+	if estimate := EstimateText(watchTx, time.Now()); len(estimate) > 0 {
+		blocks = append(blocks, slack.NewContextBlock("", markdown(estimate)))
+	}
+	if len(buttons) > 0 {
+		blocks = append(blocks, slack.NewActionBlock("", buttons...))
+	}
+	return blocks
+}
+
+// StatusBlocks lays out where the watch is up to, as shown in its status message
+func StatusBlocks(watchTx models.WatchTx, explorers Explorers) []slack.Block {
+	return watchBlocks(watchTx, explorers, mempool.StatusText(watchTx))
+}
+
+// stopButton stops watching the transaction in the channel it was clicked in
+func stopButton(watchTx models.WatchTx) slack.BlockElement {
+	return slack.NewButtonBlockElement(models.ActionStopWatch, watchTx.TxID, slack.NewTextBlockObject(slack.PlainTextType, "Stop watching", false, false))
+}
+
+func markdown(text string) *slack.TextBlockObject {
+	return slack.NewTextBlockObject(slack.MarkdownType, text, false, false)
+}
+
+// shortID keeps the start and end of a txid, enough to recognise it
+func shortID(txId string) string {
+	if len(txId) <= 16 {
+		return txId
+	}
+	return txId[:8] + "…" + txId[len(txId)-8:]
+}
@@ -0,0 +1,117 @@
+package slack
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"tx-tracker/pkg/models"
+	"tx-tracker/pkg/utils"
+
+	"github.com/slack-go/slack"
+)
+
+// SlashCommand is the command registered in the slack app for managing watches without mentioning the bot
+const SlashCommand = "/txwatch"
+
+// subcommands of SlashCommand
+const (
+	SubcommandAdd    = "add"
+	SubcommandList   = "list"
+	SubcommandRemove = "remove"
+	SubcommandStatus = "status"
+	SubcommandHelp   = "help"
+)
+
+// HelpText explains the subcommands of SlashCommand
+var HelpText = strings.Join([]string{
+	fmt.Sprintf("*%s* watches bitcoin transactions without mentioning the bot:", SlashCommand),
+	fmt.Sprintf("• `%s add txId: <id> confirms: 3 network: testnet` watches a transaction, or `address: <address>` the first payment to an address", SlashCommand),
+	fmt.Sprintf("• `%s list` lists what is being watched in this channel", SlashCommand),
+	fmt.Sprintf("• `%s status <txid or address>` shows how far along a watch is", SlashCommand),
+	fmt.Sprintf("• `%s remove <txid or address>` stops watching it", SlashCommand),
+}, "\n")
+
+// HandleSlashCommand runs a SlashCommand, returning the ephemeral reply for the user who ran it. Only a new watch
+// is posted to the channel, everything else is just for the user.
+func HandleSlashCommand(command slack.SlashCommand, client *slack.Client, watchTransaction chan models.WatchTx, store *utils.WatchStore, modes MessageModes, notify utils.NotifyPolicy, explorers Explorers) (*Reply, error) {
+	subcommand, args, _ := strings.Cut(strings.TrimSpace(command.Text), " ")
+	switch strings.ToLower(subcommand) {
+	case SubcommandAdd:
+		watchTx, errConv := utils.ParseWatch(args, notify)
+		if errConv != nil {
+			return ephemeral(fmt.Sprintf("Failed to setup watcher, check your format? %s", errConv)), nil
+		}
+		watchTx.Channel = command.ChannelID
+		watchTx.Frontend = models.FrontendSlack
+		watchTx.User = command.UserID
+		text := fmt.Sprintf("<@%s> %s", command.UserID, utils.WatchingText(*watchTx))
+		err := StartWatch(*watchTx, "", modes.Mode(command.ChannelID) == ModeThread, text, client, watchTransaction)
+		if err != nil {
+			return ephemeral(fmt.Sprintf("The watch was set up but it couldn't be posted to the channel, is the bot a member of it? %s", err)), err
+		}
+		return nil, nil
+	case SubcommandList:
+		return ephemeral(utils.ListText(store, models.FrontendSlack, command.ChannelID)), nil
+	case SubcommandRemove:
+		id, errConv := commandSubject(args)
+		if errConv != nil {
+			return ephemeral(fmt.Sprintf("Failed to stop watching, check your format? %s", errConv)), nil
+		}
+		removed := utils.RemoveWatches(store, models.FrontendSlack, command.ChannelID, id)
+		if len(removed) == 0 {
+			return ephemeral(utils.NotWatchedText(models.FrontendSlack, id)), nil
+		}
+		for _, watchTx := range removed {
+			FinishStatusMessage(watchTx, fmt.Sprintf("<@%s> stopped watching %s", command.UserID, id), client, explorers)
+		}
+		return ephemeral(utils.UnwatchedText(id)), nil
+	case SubcommandStatus:
+		id, errConv := commandSubject(args)
+		if errConv != nil {
+			return ephemeral(fmt.Sprintf("Failed to look up the watch, check your format? %s", errConv)), nil
+		}
+		blocks := []slack.Block{}
+		for _, watchTx := range utils.WatchesForChannel(store, models.FrontendSlack, command.ChannelID) {
+			if watchTx.TxID == id || watchTx.Address == id {
+				blocks = append(blocks, StatusBlocks(watchTx, explorers)...)
+			}
+		}
+		if len(blocks) == 0 {
+			return ephemeral(utils.NotWatchedText(models.FrontendSlack, id)), nil
+		}
+		reply := ephemeral(fmt.Sprintf("The status of %s", id))
+		reply.Blocks = blocks
+		return reply, nil
+	case SubcommandHelp, "":
+		return ephemeral(HelpText), nil
+	}
+	return ephemeral(fmt.Sprintf("Unknown command `%s`\n%s", subcommand, HelpText)), nil
+}
+
+// commandSubject returns the txid or address a subcommand is about, given on its own or as "txId: <id>"
+func commandSubject(args string) (string, error) {
+	if strings.Contains(args, ": ") {
+		watchTx, err := utils.ParseMessage(args)
+		if err != nil {
+			return "", err
+		}
+		return utils.WatchSubject(*watchTx), nil
+	}
+	fields := strings.Fields(args)
+	if len(fields) != 1 {
+		return "", errors.New("give the txId or address on its own, ie `remove <txid>`")
+	}
+	return fields[0], nil
+}
+
+// Reply is the response to a slash command, sent back with its acknowledgement
+type Reply struct {
+	ResponseType string        `json:"response_type"`
+	Text         string        `json:"text"`
+	Blocks       []slack.Block `json:"blocks,omitempty"`
+}
+
+func ephemeral(text string) *Reply {
+	return &Reply{ResponseType: slack.ResponseTypeEphemeral, Text: text}
+}
@@ -0,0 +1,89 @@
+package slack
+
+import (
+	"fmt"
+	"time"
+
+	"tx-tracker/pkg/models"
+	"tx-tracker/pkg/utils"
+
+	"github.com/slack-go/slack"
+)
+
+// homeWatchLimit keeps the home view under slack's 100 block limit, each watch takes 3 blocks
+const homeWatchLimit = 30
+
+// PublishHome shows the user the watches they asked for in every channel on the home tab of the app
+func PublishHome(user string, client *slack.Client, store *utils.WatchStore) error {
+	view := slack.HomeTabViewRequest{
+		Type:   slack.VTHomeTab,
+		Blocks: slack.Blocks{BlockSet: HomeBlocks(utils.WatchesForUser(store, models.FrontendSlack, user), time.Now().UTC())},
+	}
+	_, err := client.PublishView(user, view, "")
+	if err != nil {
+		return fmt.Errorf("failed to publish home view: %w", err)
+	}
+	return nil
+}
+
+// HomeBlocks lays out the watches for the home tab, each with its progress and buttons to cancel or extend it
+func HomeBlocks(watches []models.WatchTx, now time.Time) []slack.Block {
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, "Your watches", false, false)),
+	}
+	if len(watches) == 0 {
+		text := fmt.Sprintf("You aren't watching anything, mention the bot or use `%s add` in a channel to start", SlashCommand)
+		return append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil))
+	}
+	for i, watchTx := range watches {
+		if i == homeWatchLimit {
+			text := fmt.Sprintf("and %d more, use `%s list` in their channels to see them", len(watches)-homeWatchLimit, SlashCommand)
+			blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, text, false, false)))
+			break
+		}
+		text := fmt.Sprintf("<#%s> %s\n%s", watchTx.Channel, utils.DescribeWatch(watchTx, now), ProgressBar(watchTx.ConfsCount, watchTx.Confs))
+		cancel := slack.NewButtonBlockElement(models.ActionCancelWatch, watchTx.ID, slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false))
+		cancel.Style = slack.StyleDanger
+		cancel.WithConfirm(slack.NewConfirmationBlockObject(
+			slack.NewTextBlockObject(slack.PlainTextType, "Cancel this watch?", false, false),
+			slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("No more updates for %s will be posted in <#%s>", utils.WatchSubject(watchTx), watchTx.Channel), false, false),
+			slack.NewTextBlockObject(slack.PlainTextType, "Cancel it", false, false),
+			slack.NewTextBlockObject(slack.PlainTextType, "Keep it", false, false),
+		))
+		extend := slack.NewButtonBlockElement(models.ActionExtendWatch, watchTx.ID, slack.NewTextBlockObject(slack.PlainTextType, fmt.Sprintf("Keep for %d more days", int(utils.WatchExtension.Hours()/24)), false, false))
+		blocks = append(blocks,
+			slack.NewDividerBlock(),
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+			slack.NewActionBlock("", cancel, extend),
+		)
+	}
+	return blocks
+}
+
+// CancelWatch stops a watch the user asked for, letting its channel know
+func CancelWatch(user string, id string, client *slack.Client, store *utils.WatchStore, explorers Explorers) error {
+	watchTx, ok := store.Get(id)
+	if !ok || watchTx.User != user {
+		//finished or cancelled since the home view was published
+		return nil
+	}
+	if _, ok := store.Remove(id, models.FinishCancelled); !ok {
+		return nil
+	}
+	text := fmt.Sprintf("<@%s> stopped watching %s from the app home", user, utils.WatchSubject(watchTx))
+	FinishStatusMessage(watchTx, text, client, explorers)
+	attachment := slack.Attachment{}
+	attachment.Text = text
+	attachment.Color = "#4af030"
+	_, err := postMessage(watchTx.Channel, watchTx.ThreadID, client, slack.MsgOptionAttachments(attachment))
+	return err
+}
+
+// ExtendWatch keeps a watch the user asked for another utils.WatchExtension
+func ExtendWatch(user string, id string, store *utils.WatchStore) {
+	watchTx, ok := store.Get(id)
+	if !ok || watchTx.User != user {
+		return
+	}
+	utils.ExtendWatch(store, id)
+}
@@ -0,0 +1,68 @@
+package slack
+
+import (
+	"fmt"
+	"strings"
+)
+
+// how the updates of a watch are posted to a channel
+const (
+	// ModeThread replies in the thread of the request and keeps a pinned status message there up to date
+	ModeThread = "thread"
+	// ModeChannel posts every update to the channel itself
+	ModeChannel = "channel"
+)
+
+// MessageModes is the mode of each channel, channels not listed use Default
+type MessageModes struct {
+	Default  string
+	Channels map[string]string
+}
+
+// ParseMessageModes reads the default mode and a "channel:mode, channel:mode" list of channel overrides, the default
+// falls back to ModeThread when empty
+func ParseMessageModes(defaultMode string, channelModes string) (MessageModes, error) {
+	modes := MessageModes{Default: ModeThread, Channels: map[string]string{}}
+	if len(strings.TrimSpace(defaultMode)) > 0 {
+		mode, err := parseMode(defaultMode)
+		if err != nil {
+			return modes, err
+		}
+		modes.Default = mode
+	}
+	for _, entry := range strings.Split(channelModes, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		channel, rawMode, found := strings.Cut(entry, ":")
+		if !found || len(strings.TrimSpace(channel)) == 0 {
+			return modes, fmt.Errorf("malformed channel mode %q, expected channel:mode", entry)
+		}
+		mode, err := parseMode(rawMode)
+		if err != nil {
+			return modes, err
+		}
+		modes.Channels[strings.TrimSpace(channel)] = mode
+	}
+	return modes, nil
+}
+
+func parseMode(raw string) (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(raw))
+	if mode != ModeThread && mode != ModeChannel {
+		return "", fmt.Errorf("unknown message mode %q, expected %q or %q", raw, ModeThread, ModeChannel)
+	}
+	return mode, nil
+}
+
+// Mode returns how updates are posted in the channel
+func (m MessageModes) Mode(channel string) string {
+	if mode, ok := m.Channels[channel]; ok {
+		return mode
+	}
+	if len(m.Default) == 0 {
+		return ModeThread
+	}
+	return m.Default
+}
@@ -0,0 +1,124 @@
+package slack
+
+import (
+	"fmt"
+	"log"
+	"tx-tracker/pkg/mempool"
+	"tx-tracker/pkg/models"
+
+	"github.com/slack-go/slack"
+)
+
+// Notifier posts the messages to the channel the watch was requested from, or the thread of the request, and
+// keeps the watch's status message up to date
+type Notifier struct {
+	Client    *slack.Client
+	Explorers Explorers
+}
+
+func NewNotifier(client *slack.Client, explorers Explorers) *Notifier {
+	return &Notifier{Client: client, Explorers: explorers}
+}
+
+// threadOptions adds the thread of watches whose updates are posted as replies, broadcast also shows the reply in
+// the channel for the messages everyone should see
+func threadOptions(watchTx models.WatchTx, broadcast bool, options ...slack.MsgOption) []slack.MsgOption {
+	if len(watchTx.ThreadID) == 0 {
+		return options
+	}
+	options = append(options, slack.MsgOptionTS(watchTx.ThreadID))
+	if broadcast {
+		options = append(options, slack.MsgOptionBroadcast())
+	}
+	return options
+}
+
+// postBlocks posts a Block Kit notification for the watch, text is what shows in the notification itself
+func (n *Notifier) postBlocks(watchTx models.WatchTx, broadcast bool, text string, blocks []slack.Block) {
+	_, _, err := n.Client.PostMessage(watchTx.Channel, threadOptions(watchTx, broadcast, slack.MsgOptionText(text, false), slack.MsgOptionBlocks(blocks...))...)
+	if err != nil {
+		log.Printf("failed to post message: %s", err.Error())
+	}
+}
+
+func (n *Notifier) SendErrorMessage(watchTx models.WatchTx, text string) {
+	headline := ":x: " + mempool.ErrorText(text)
+	n.postBlocks(watchTx, false, headline, []slack.Block{slack.NewSectionBlock(markdown(headline), nil, nil)})
+}
+
+func (n *Notifier) SendMempoolMessage(watchTx models.WatchTx) {
+	text := mempool.MempoolText(watchTx)
+	n.postBlocks(watchTx, false, text, watchBlocks(watchTx, n.Explorers, ":mag: "+text, stopButton(watchTx)))
+	UpdateStatusMessage(watchTx, n.Client, n.Explorers)
+}
+
+func (n *Notifier) SendDroppedMessage(watchTx models.WatchTx) {
+	text := mempool.DroppedText(watchTx)
+	n.postBlocks(watchTx, false, text, watchBlocks(watchTx, n.Explorers, ":warning: "+text, stopButton(watchTx)))
+	UpdateStatusMessage(watchTx, n.Client, n.Explorers)
+}
+
+func (n *Notifier) SendReplacedMessage(watchTx models.WatchTx) {
+	text := mempool.ReplacedText(watchTx)
+	move := slack.NewButtonBlockElement(models.ActionMoveWatch, fmt.Sprintf("%s:%s", watchTx.TxID, watchTx.ReplacedBy), slack.NewTextBlockObject(slack.PlainTextType, "Watch the replacement instead", false, false))
+	move.Style = slack.StylePrimary
+	n.postBlocks(watchTx, false, text, watchBlocks(watchTx, n.Explorers, ":twisted_rightwards_arrows: "+text, move, stopButton(watchTx)))
+	UpdateStatusMessage(watchTx, n.Client, n.Explorers)
+}
+
+func (n *Notifier) SendReorgMessage(watchTx models.WatchTx, lostConfs int) {
+	text := "<!here> " + mempool.ReorgText(watchTx, lostConfs)
+	n.postBlocks(watchTx, true, text, watchBlocks(watchTx, n.Explorers, text, stopButton(watchTx)))
+	UpdateStatusMessage(watchTx, n.Client, n.Explorers)
+}
+
+func (n *Notifier) SendFirstConfMessage(watchTx models.WatchTx, confirmed models.ConfirmedPayload) {
+	text := mempool.FirstConfText(watchTx, confirmed)
+	n.postBlocks(watchTx, false, text, watchBlocks(watchTx, n.Explorers, ":white_check_mark: "+text, stopButton(watchTx)))
+	UpdateStatusMessage(watchTx, n.Client, n.Explorers)
+}
+
+func (n *Notifier) SendUpdatedConfMessage(watchTx models.WatchTx) {
+	text := mempool.UpdatedConfText(watchTx)
+	n.postBlocks(watchTx, false, text, watchBlocks(watchTx, n.Explorers, ":chains: "+text, stopButton(watchTx)))
+	UpdateStatusMessage(watchTx, n.Client, n.Explorers)
+}
+
+func (n *Notifier) SendFinalMessage(watchTx models.WatchTx) {
+	text := mempool.FinalText(watchTx)
+	n.postBlocks(watchTx, true, text, watchBlocks(watchTx, n.Explorers, ":tada: "+text))
+	FinishStatusMessage(watchTx, mempool.SettledText(watchTx), n.Client, n.Explorers)
+}
+
+// UpdateStatusMessage edits the watch's status message to where it is up to, nothing is done for watches without one
+func UpdateStatusMessage(watchTx models.WatchTx, slackClient *slack.Client, explorers Explorers) {
+	if len(watchTx.StatusMessageID) == 0 {
+		return
+	}
+	text := mempool.StatusText(watchTx)
+	buttons := []slack.BlockElement{}
+	if len(watchTx.TxID) > 0 {
+		buttons = append(buttons, stopButton(watchTx))
+	}
+	updateStatus(watchTx, text, watchBlocks(watchTx, explorers, text, buttons...), slackClient)
+}
+
+// FinishStatusMessage replaces the status message with text once the watch has stopped, and unpins it
+func FinishStatusMessage(watchTx models.WatchTx, text string, slackClient *slack.Client, explorers Explorers) {
+	if len(watchTx.StatusMessageID) == 0 {
+		return
+	}
+	updateStatus(watchTx, text, watchBlocks(watchTx, explorers, text), slackClient)
+	err := slackClient.RemovePin(watchTx.Channel, slack.NewRefToMessage(watchTx.Channel, watchTx.StatusMessageID))
+	if err != nil {
+		log.Printf("failed to unpin status message: %s", err.Error())
+	}
+}
+
+// updateStatus edits the status message, clearing the attachments of status messages posted before it used blocks
+func updateStatus(watchTx models.WatchTx, text string, blocks []slack.Block, slackClient *slack.Client) {
+	_, _, _, err := slackClient.UpdateMessage(watchTx.Channel, watchTx.StatusMessageID, slack.MsgOptionText(text, false), slack.MsgOptionBlocks(blocks...), slack.MsgOptionAttachments([]slack.Attachment{}...))
+	if err != nil {
+		log.Printf("failed to update status message: %s", err.Error())
+	}
+}
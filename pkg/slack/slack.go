@@ -2,23 +2,19 @@ package slack
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log"
-	"regexp"
-	"strconv"
 	"strings"
-	"time"
 
 	"tx-tracker/pkg/models"
+	"tx-tracker/pkg/utils"
 
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
 	"github.com/slack-go/slack/socketmode"
 )
 
-func ListenForSlackMessages(ctx context.Context, client *slack.Client, socketClient *socketmode.Client, watchTransaction chan models.WatchTx) {
-	defer close(watchTransaction)
+func ListenForSlackMessages(ctx context.Context, client *slack.Client, socketClient *socketmode.Client, watchTransaction chan models.WatchTx, store *utils.WatchStore, modes MessageModes, notify utils.NotifyPolicy, explorers Explorers) {
 	for {
 		select {
 		case <-ctx.Done():
@@ -38,114 +34,241 @@ func ListenForSlackMessages(ctx context.Context, client *slack.Client, socketCli
 
 				socketClient.Ack(*event.Request)
 				log.Println(eventsAPI)
-				err := HandleEventMessage(eventsAPI, client, watchTransaction)
+				err := HandleEventMessage(eventsAPI, client, watchTransaction, store, modes, notify, explorers)
 				if err != nil {
-					log.Fatal(err)
+					log.Printf("failed to handle event: %s", err.Error())
+				}
+
+			case socketmode.EventTypeSlashCommand:
+
+				command, ok := event.Data.(slack.SlashCommand)
+				if !ok {
+					log.Printf("Could not type cast the event to a SlashCommand: %v\n", event)
+					continue
+				}
+
+				//the reply goes back with the acknowledgement, only the user who ran the command sees it
+				reply, err := HandleSlashCommand(command, client, watchTransaction, store, modes, notify, explorers)
+				if err != nil {
+					log.Printf("failed to handle slash command: %s", err.Error())
+				}
+				if reply != nil {
+					socketClient.Ack(*event.Request, reply)
+				} else {
+					socketClient.Ack(*event.Request)
+				}
+
+			case socketmode.EventTypeInteractive:
+
+				callback, ok := event.Data.(slack.InteractionCallback)
+				if !ok {
+					log.Printf("Could not type cast the event to an InteractionCallback: %v\n", event)
+					continue
+				}
+
+				socketClient.Ack(*event.Request)
+				err := HandleInteraction(callback, client, watchTransaction, store, explorers)
+				if err != nil {
+					log.Printf("failed to handle interaction: %s", err.Error())
 				}
 			}
 		}
 	}
 }
 
-func HandleEventMessage(event slackevents.EventsAPIEvent, client *slack.Client, watchTransaction chan models.WatchTx) error {
+func HandleEventMessage(event slackevents.EventsAPIEvent, client *slack.Client, watchTransaction chan models.WatchTx, store *utils.WatchStore, modes MessageModes, notify utils.NotifyPolicy, explorers Explorers) error {
 	switch event.Type {
 	case slackevents.CallbackEvent:
 		innerEvent := event.InnerEvent
 		switch evnt := innerEvent.Data.(type) {
 		case *slackevents.AppMentionEvent:
-			err := HandleAppMentionEventToBot(evnt, client, watchTransaction)
+			err := HandleAppMentionEventToBot(evnt, client, watchTransaction, store, modes, notify, explorers)
 			if err != nil {
 				return err
 			}
+		case *slackevents.AppHomeOpenedEvent:
+			if evnt.Tab != "home" {
+				return nil
+			}
+			return PublishHome(evnt.User, client, store)
+		default:
+			log.Printf("ignoring unhandled event %s", innerEvent.Type)
 		}
 	default:
-		return errors.New("unsupported event type")
+		log.Printf("ignoring unhandled event type %s", event.Type)
 	}
 	return nil
 }
 
-func HandleAppMentionEventToBot(event *slackevents.AppMentionEvent, client *slack.Client, watchTransaction chan models.WatchTx) error {
-
-	watchTx, errConv := ParseMessage(event.Text)
-
-	if watchTx != nil {
-		watchTx.Channel = event.Channel
-		watchTransaction <- *watchTx
+func HandleAppMentionEventToBot(event *slackevents.AppMentionEvent, client *slack.Client, watchTransaction chan models.WatchTx, store *utils.WatchStore, modes MessageModes, notify utils.NotifyPolicy, explorers Explorers) error {
+	//in thread mode everything is answered in the thread of the mention, which is the mention itself when it wasn't
+	//posted in a thread already
+	thread := ""
+	if modes.Mode(event.Channel) == ModeThread {
+		thread = event.ThreadTimeStamp
+		if len(thread) == 0 {
+			thread = event.TimeStamp
+		}
 	}
-	network := "mainnet"
-	if len(watchTx.Network) > 0 {
-		network = watchTx.Network
+
+	switch utils.ParseCommand(event.Text) {
+	case utils.CommandList:
+		return HandleListCommand(event.Channel, thread, client, store)
+	case utils.CommandUnwatch:
+		watchTx, errConv := utils.ParseMessage(event.Text)
+		if errConv != nil {
+			return PostError(event.Channel, thread, fmt.Sprintf("Failed to stop watching, check your format? %s", errConv), client)
+		}
+		return HandleUnwatch(event.Channel, thread, utils.WatchSubject(*watchTx), client, store, explorers)
+	case utils.CommandUpdate:
+		watchTx, errConv := utils.ParseUpdate(event.Text)
+		if errConv != nil {
+			return PostError(event.Channel, thread, fmt.Sprintf("Failed to update watcher, check your format? %s", errConv), client)
+		}
+		return HandleUpdate(event.Channel, thread, utils.WatchSubject(*watchTx), watchTx.Confs, client, watchTransaction, store, explorers)
 	}
-	attachment := slack.Attachment{}
+
+	watchTx, errConv := utils.ParseWatch(event.Text, notify)
 	if errConv != nil {
-		attachment.Text = fmt.Sprintf("Failed to setup watcher, check your format? %s", errConv)
-		attachment.Color = "#ef3232"
-	} else {
-		attachment.Text = fmt.Sprintf("Your transaction %s on %s is being watched and you will be notified of each block until %d confirmations have occured", watchTx.TxID, network, watchTx.Confs)
-		attachment.Color = "#4af030"
+		return PostError(event.Channel, thread, fmt.Sprintf("Failed to setup watcher, check your format? %s", errConv), client)
 	}
-	_, _, err := client.PostMessage(event.Channel, slack.MsgOptionAttachments(attachment))
+	watchTx.Channel = event.Channel
+	watchTx.Frontend = models.FrontendSlack
+	watchTx.User = event.User
+	return StartWatch(*watchTx, thread, len(thread) > 0, utils.WatchingText(*watchTx), client, watchTransaction)
+}
+
+// StartWatch posts text to acknowledge a new watch, as a reply in thread when it's set, then hands the watch on to
+// be watched. When threaded the acknowledgement becomes the status message of the watch, edited as it progresses
+// and pinned until it's done, and the updates are replied in thread or the thread of the acknowledgement.
+func StartWatch(watchTx models.WatchTx, thread string, threaded bool, text string, client *slack.Client, watchTransaction chan models.WatchTx) error {
+	section := slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, ":eyes: "+text, false, false), nil, nil)
+	timestamp, err := postMessage(watchTx.Channel, thread, client, slack.MsgOptionText(text, false), slack.MsgOptionBlocks(section))
 	if err != nil {
-		return fmt.Errorf("failed to post message: %w", err)
+		watchTransaction <- watchTx
+		return err
+	}
+	if threaded {
+		watchTx.ThreadID = thread
+		if len(thread) == 0 {
+			watchTx.ThreadID = timestamp
+		}
+		watchTx.StatusMessageID = timestamp
+		errPin := client.AddPin(watchTx.Channel, slack.NewRefToMessage(watchTx.Channel, timestamp))
+		if errPin != nil {
+			log.Printf("failed to pin status message: %s", errPin.Error())
+		}
 	}
+	watchTransaction <- watchTx
 	return nil
 }
 
-func ParseMessage(rawMessage string) (*models.WatchTx, error) {
-
-	transactionMatch, errTransRegex := regexp.Compile("(txId: [0-9|a-z|A-Z]+)")
-	if errTransRegex != nil {
-		return nil, errTransRegex
-	}
-	confirmsMatch, errConfRegex := regexp.Compile("(confirms: [0-9|a-z|A-Z]+)")
-	if errConfRegex != nil {
-		return nil, errConfRegex
+// postMessage posts to the channel, as a reply in thread when it's set, returning the timestamp of the message
+func postMessage(channel string, thread string, client *slack.Client, options ...slack.MsgOption) (string, error) {
+	if len(thread) > 0 {
+		options = append(options, slack.MsgOptionTS(thread))
 	}
-	networkMatch, errNetworkReges := regexp.Compile("(network: [a-z|A-Z]+)")
-	if errNetworkReges != nil {
-		return nil, errNetworkReges
+	_, timestamp, err := client.PostMessage(channel, options...)
+	if err != nil {
+		return "", fmt.Errorf("failed to post message: %w", err)
 	}
-	transactionText := transactionMatch.Find([]byte(rawMessage))
+	return timestamp, nil
+}
 
-	var txId *string
-	if transactionText != nil {
-		rawId := strings.Split(string(transactionText), ": ")[1]
-		txId = &rawId
-	}
-	networkText := networkMatch.Find([]byte(rawMessage))
+func HandleListCommand(channel string, thread string, client *slack.Client, store *utils.WatchStore) error {
+	attachment := slack.Attachment{}
+	attachment.Text = utils.ListText(store, models.FrontendSlack, channel)
+	attachment.Color = "#4af030"
+	_, err := postMessage(channel, thread, client, slack.MsgOptionAttachments(attachment))
+	return err
+}
 
-	var network string = ""
-	if networkText != nil {
-		rawNetwork := strings.Split(string(networkText), ": ")[1]
-		network = rawNetwork
+func HandleUnwatch(channel string, thread string, id string, client *slack.Client, store *utils.WatchStore, explorers Explorers) error {
+	removed := utils.RemoveWatches(store, models.FrontendSlack, channel, id)
+	if len(removed) == 0 {
+		return PostError(channel, thread, utils.NotWatchedText(models.FrontendSlack, id), client)
+	}
+	for _, watchTx := range removed {
+		FinishStatusMessage(watchTx, fmt.Sprintf("Stopped watching %s", id), client, explorers)
 	}
-	confirmText := confirmsMatch.Find([]byte(rawMessage))
+	attachment := slack.Attachment{}
+	attachment.Text = utils.UnwatchedText(id)
+	attachment.Color = "#4af030"
+	_, err := postMessage(channel, thread, client, slack.MsgOptionAttachments(attachment))
+	return err
+}
 
-	var confirms *int
-	if confirmText != nil {
-		rawConfirms := strings.Split(string(confirmText), ": ")[1]
-		convConfirms, err := strconv.Atoi(rawConfirms)
-		if err != nil {
-			return nil, err
+func HandleUpdate(channel string, thread string, id string, confs int, client *slack.Client, watchTransaction chan models.WatchTx, store *utils.WatchStore, explorers Explorers) error {
+	updated := utils.UpdateWatchConfs(store, models.FrontendSlack, channel, id, confs, watchTransaction)
+	if len(updated) == 0 {
+		return PostError(channel, thread, utils.NotWatchedText(models.FrontendSlack, id), client)
+	}
+	for _, watchTx := range updated {
+		//watches that met their target get their status finished with the final message
+		if watchTx.ConfsCount < watchTx.Confs {
+			UpdateStatusMessage(watchTx, client, explorers)
 		}
-		confirms = &convConfirms
 	}
+	attachment := slack.Attachment{}
+	attachment.Text = utils.UpdatedText(id, confs, updated[0].ConfsCount)
+	attachment.Color = "#4af030"
+	_, err := postMessage(channel, thread, client, slack.MsgOptionAttachments(attachment))
+	return err
+}
+
+func PostError(channel string, thread string, text string, client *slack.Client) error {
+	attachment := slack.Attachment{}
+	attachment.Text = text
+	attachment.Color = "#ef3232"
+	_, err := postMessage(channel, thread, client, slack.MsgOptionAttachments(attachment))
+	return err
+}
 
-	if txId != nil {
-		if confirms == nil {
-			defaultCount := 6
-			confirms = &defaultCount
+func HandleInteraction(callback slack.InteractionCallback, client *slack.Client, watchTransaction chan models.WatchTx, store *utils.WatchStore, explorers Explorers) error {
+	if callback.Type != slack.InteractionTypeBlockActions {
+		return nil
+	}
+	for _, action := range callback.ActionCallback.BlockActions {
+		switch action.ActionID {
+		case models.ActionMoveWatch:
+			oldTxId, newTxId, found := strings.Cut(action.Value, ":")
+			if !found {
+				return fmt.Errorf("malformed %s value: %s", action.ActionID, action.Value)
+			}
+			err := MoveWatch(callback.Channel.ID, callback.Message.ThreadTimestamp, oldTxId, newTxId, client, watchTransaction, store)
+			if err != nil {
+				return err
+			}
+		case models.ActionStopWatch:
+			err := HandleUnwatch(callback.Channel.ID, callback.Message.ThreadTimestamp, action.Value, client, store, explorers)
+			if err != nil {
+				return err
+			}
+		case models.ActionCancelWatch:
+			err := CancelWatch(callback.User.ID, action.Value, client, store, explorers)
+			if err != nil {
+				log.Printf("failed to cancel watch: %s", err.Error())
+			}
+			return PublishHome(callback.User.ID, client, store)
+		case models.ActionExtendWatch:
+			ExtendWatch(callback.User.ID, action.Value, store)
+			return PublishHome(callback.User.ID, client, store)
 		}
-		timeRequest := time.Now().UTC().Unix()
-		return &models.WatchTx{
-			TxID:               *txId,
-			Confs:              *confirms,
-			ConfsCount:         0,
-			Network:            network,
-			ConfirmBlockHeight: 0,
-			TimeRequested:      timeRequest,
-		}, nil
+	}
+	return nil
+}
+
+// MoveWatch replaces the channel's watch on oldTxId with one on newTxId, keeping the confirmation target
+func MoveWatch(channel string, thread string, oldTxId string, newTxId string, client *slack.Client, watchTransaction chan models.WatchTx, store *utils.WatchStore) error {
+	attachment := slack.Attachment{}
+	moved := utils.MoveWatches(store, models.FrontendSlack, channel, oldTxId, newTxId, watchTransaction)
+	if moved {
+		attachment.Text = fmt.Sprintf("Your watch has moved from %s to the replacement %s", oldTxId, newTxId)
+		attachment.Color = "#4af030"
 	} else {
-		return nil, fmt.Errorf("a txId is required, in the format: 'txId: <transacitonId to watch>'")
+		attachment.Text = fmt.Sprintf("The transaction %s is no longer being watched in this channel", oldTxId)
+		attachment.Color = "#ef3232"
 	}
+	_, err := postMessage(channel, thread, client, slack.MsgOptionAttachments(attachment))
+	return err
 }
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+	"tx-tracker/pkg/models"
+
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS watches (
+	id          TEXT PRIMARY KEY,
+	tx_id       TEXT NOT NULL,
+	address     TEXT NOT NULL,
+	network     TEXT NOT NULL,
+	channel     TEXT NOT NULL,
+	confs       INTEGER NOT NULL,
+	confs_count INTEGER NOT NULL,
+	state       TEXT NOT NULL,
+	data        TEXT NOT NULL,
+	updated_at  INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS watch_history (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	watch_id    TEXT NOT NULL,
+	tx_id       TEXT NOT NULL,
+	address     TEXT NOT NULL,
+	network     TEXT NOT NULL,
+	channel     TEXT NOT NULL,
+	confs       INTEGER NOT NULL,
+	confs_count INTEGER NOT NULL,
+	reason      TEXT NOT NULL,
+	data        TEXT NOT NULL,
+	finished_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS watch_history_tx_id ON watch_history (tx_id);
+`
+
+// SQLite keeps the active watches in an embedded sqlite database, writing every change as it happens, along with
+// the history of the watches that completed, expired or were cancelled
+type SQLite struct {
+	db *sql.DB
+}
+
+func NewSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	//sqlite only allows one writer, sharing a single connection avoids busy errors between our own goroutines
+	db.SetMaxOpenConns(1)
+	for _, pragma := range []string{"PRAGMA journal_mode=WAL", "PRAGMA synchronous=NORMAL", "PRAGMA busy_timeout=5000"} {
+		if _, err := db.Exec(pragma); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLite{db: db}, nil
+}
+
+// LoadWatches returns every active watch
+func (s *SQLite) LoadWatches() ([]models.WatchTx, error) {
+	rows, err := s.db.Query("SELECT data FROM watches ORDER BY updated_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	watches := []models.WatchTx{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		watchTx := models.WatchTx{}
+		if err := json.Unmarshal([]byte(data), &watchTx); err != nil {
+			return nil, err
+		}
+		watches = append(watches, watchTx)
+	}
+	return watches, rows.Err()
+}
+
+func (s *SQLite) SaveWatch(watchTx models.WatchTx) error {
+	data, err := json.Marshal(watchTx)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO watches (id, tx_id, address, network, channel, confs, confs_count, state, data, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET tx_id = excluded.tx_id, address = excluded.address, confs = excluded.confs,
+			confs_count = excluded.confs_count, state = excluded.state, data = excluded.data, updated_at = excluded.updated_at`,
+		watchTx.ID, watchTx.TxID, watchTx.Address, watchTx.Network, watchTx.Channel, watchTx.Confs, watchTx.ConfsCount, watchTx.State, string(data), time.Now().UTC().Unix())
+	return err
+}
+
+// FinishWatch moves a watch from the active watches into the history
+func (s *SQLite) FinishWatch(watchTx models.WatchTx, reason string) error {
+	data, err := json.Marshal(watchTx)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM watches WHERE id = ?", watchTx.ID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO watch_history (watch_id, tx_id, address, network, channel, confs, confs_count, reason, data, finished_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		watchTx.ID, watchTx.TxID, watchTx.Address, watchTx.Network, watchTx.Channel, watchTx.Confs, watchTx.ConfsCount, reason, string(data), time.Now().UTC().Unix())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
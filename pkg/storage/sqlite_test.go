@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"tx-tracker/pkg/models"
+)
+
+func openTemp(t *testing.T, path string) *SQLite {
+	t.Helper()
+	db, err := NewSQLite(path)
+	if err != nil {
+		t.Fatalf("NewSQLite failed: %s", err)
+	}
+	return db
+}
+
+func TestSQLiteRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watches.db")
+	db := openTemp(t, path)
+
+	pending := models.WatchTx{ID: "mainnet:aa:C1", TxID: "aa", Channel: "C1", Confs: 6, TimeRequested: 1690000000}
+	paid := models.WatchTx{ID: "testnet:tb1qaddress:matrix/!room", Address: "tb1qaddress", Network: "testnet", Channel: "!room",
+		Frontend: models.FrontendMatrix, Confs: 2, Notify: "ops@example.com", TimeRequested: 1690000001}
+	for _, watchTx := range []models.WatchTx{pending, paid} {
+		if err := db.SaveWatch(watchTx); err != nil {
+			t.Fatalf("SaveWatch failed: %s", err)
+		}
+	}
+	//saving again replaces the row rather than adding another
+	pending.ConfsCount = 1
+	pending.ConfirmBlockHeight = 800000
+	pending.ConfirmBlockHash = "a1"
+	pending.State = models.StateConfirmed
+	if err := db.SaveWatch(pending); err != nil {
+		t.Fatalf("SaveWatch failed: %s", err)
+	}
+	paid.TxID = "bb"
+	paid.ConfsCount = 2
+	if err := db.FinishWatch(paid, models.FinishCompleted); err != nil {
+		t.Fatalf("FinishWatch failed: %s", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	db = openTemp(t, path)
+	defer db.Close()
+	watches, err := db.LoadWatches()
+	if err != nil {
+		t.Fatalf("LoadWatches failed: %s", err)
+	}
+	if !reflect.DeepEqual(watches, []models.WatchTx{pending}) {
+		t.Errorf("got %+v, want only the updated active watch", watches)
+	}
+
+	rows, err := db.db.Query("SELECT watch_id, tx_id, address, network, channel, confs, confs_count, reason FROM watch_history")
+	if err != nil {
+		t.Fatalf("failed to query the history: %s", err)
+	}
+	defer rows.Close()
+	type historyRow struct {
+		watchId, txId, address, network, channel string
+		confs, confsCount                        int
+		reason                                   string
+	}
+	history := []historyRow{}
+	for rows.Next() {
+		row := historyRow{}
+		if err := rows.Scan(&row.watchId, &row.txId, &row.address, &row.network, &row.channel, &row.confs, &row.confsCount, &row.reason); err != nil {
+			t.Fatalf("failed to read the history: %s", err)
+		}
+		history = append(history, row)
+	}
+	want := []historyRow{{watchId: paid.ID, txId: "bb", address: "tb1qaddress", network: "testnet", channel: "!room", confs: 2, confsCount: 2, reason: models.FinishCompleted}}
+	if !reflect.DeepEqual(history, want) {
+		t.Errorf("got history %+v, want %+v", history, want)
+	}
+}
@@ -0,0 +1,15 @@
+package storage
+
+import (
+	"tx-tracker/pkg/models"
+	"tx-tracker/pkg/utils"
+)
+
+// Storage keeps watches between restarts by recording every change to the utils.WatchStore as it is made, rather
+// than the batches of changes the utils.Snapshotter saves to the gob file
+type Storage interface {
+	utils.Persister
+	// LoadWatches returns the watches that were active when the bot stopped
+	LoadWatches() ([]models.WatchTx, error)
+	Close() error
+}
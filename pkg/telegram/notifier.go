@@ -0,0 +1,64 @@
+package telegram
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"tx-tracker/pkg/mempool"
+	"tx-tracker/pkg/models"
+	"tx-tracker/pkg/utils"
+)
+
+// Notifier sends the messages about watches requested from telegram to the chat that asked
+type Notifier struct {
+	Bot *Bot
+}
+
+func NewNotifier(bot *Bot) *Notifier {
+	return &Notifier{Bot: bot}
+}
+
+func (n *Notifier) send(watchTx models.WatchTx, text string) {
+	err := n.Bot.SendMessage(watchTx.Channel, text)
+	if err != nil {
+		log.Printf("failed to send telegram message: %s", err.Error())
+	}
+}
+
+func (n *Notifier) SendErrorMessage(watchTx models.WatchTx, text string) {
+	n.send(watchTx, mempool.ErrorText(text))
+}
+
+func (n *Notifier) SendMempoolMessage(watchTx models.WatchTx) {
+	n.send(watchTx, mempool.MempoolText(watchTx))
+}
+
+func (n *Notifier) SendDroppedMessage(watchTx models.WatchTx) {
+	n.send(watchTx, mempool.DroppedText(watchTx))
+}
+
+// SendReplacedMessage has no button to move the watch, telegram's 64 byte callback data can't hold a txid with
+// the action, so the commands to move it are spelled out instead
+func (n *Notifier) SendReplacedMessage(watchTx models.WatchTx) {
+	watch := fmt.Sprintf("/watch %s %d", watchTx.ReplacedBy, watchTx.Confs)
+	if network := utils.NormalizeNetwork(watchTx.Network); network != "mainnet" {
+		watch = fmt.Sprintf("%s %s", watch, network)
+	}
+	n.send(watchTx, fmt.Sprintf("%s\nTo watch the replacement instead: /unwatch %s then %s", mempool.ReplacedText(watchTx), watchTx.TxID, watch))
+}
+
+func (n *Notifier) SendReorgMessage(watchTx models.WatchTx, lostConfs int) {
+	n.send(watchTx, strings.Replace(mempool.ReorgText(watchTx, lostConfs), ":rotating_light:", "\U0001F6A8", 1))
+}
+
+func (n *Notifier) SendFirstConfMessage(watchTx models.WatchTx, confirmed models.ConfirmedPayload) {
+	n.send(watchTx, mempool.FirstConfText(watchTx, confirmed))
+}
+
+func (n *Notifier) SendUpdatedConfMessage(watchTx models.WatchTx) {
+	n.send(watchTx, mempool.UpdatedConfText(watchTx))
+}
+
+func (n *Notifier) SendFinalMessage(watchTx models.WatchTx) {
+	n.send(watchTx, mempool.FinalText(watchTx))
+}
@@ -0,0 +1,291 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"tx-tracker/pkg/models"
+	"tx-tracker/pkg/utils"
+)
+
+const defaultApiUrl = "https://api.telegram.org"
+
+// pollTimeout is how long telegram holds a getUpdates request open waiting for a message
+const pollTimeout = 50
+
+// Bot talks to the Telegram Bot API, new messages are fetched by long polling so no public endpoint is needed
+type Bot struct {
+	apiUrl string
+	token  string
+	client *http.Client
+}
+
+func NewBot(token string) *Bot {
+	return &Bot{
+		apiUrl: defaultApiUrl,
+		token:  token,
+		client: &http.Client{Timeout: (pollTimeout + 10) * time.Second},
+	}
+}
+
+// APIError is a request the Bot API answered with ok false
+type APIError struct {
+	Method      string
+	ErrorCode   int
+	Description string
+	// RetryAfter is how many seconds to wait when the bot is being rate limited
+	RetryAfter int
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("telegram %s returned %d: %s", e.Method, e.ErrorCode, e.Description)
+}
+
+type apiResponse struct {
+	Ok          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+type Update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *Message `json:"message"`
+}
+
+type Message struct {
+	MessageID int64  `json:"message_id"`
+	Chat      Chat   `json:"chat"`
+	Text      string `json:"text"`
+}
+
+type Chat struct {
+	ID int64 `json:"id"`
+}
+
+// call posts params as JSON to a Bot API method and decodes its result into result, which can be nil
+func (b *Bot) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/bot%s/%s", b.apiUrl, b.token, method), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		//the url holds the token, keep it out of the logs
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("telegram %s failed: %w", method, urlErr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	apiResp := apiResponse{}
+	err = json.Unmarshal(raw, &apiResp)
+	if err != nil {
+		return fmt.Errorf("telegram %s returned %s: %s", method, resp.Status, strings.TrimSpace(string(raw)))
+	}
+	if !apiResp.Ok {
+		return &APIError{Method: method, ErrorCode: apiResp.ErrorCode, Description: apiResp.Description, RetryAfter: apiResp.Parameters.RetryAfter}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(apiResp.Result, result)
+}
+
+// GetUpdates waits for the messages after offset
+func (b *Bot) GetUpdates(ctx context.Context, offset int64) ([]Update, error) {
+	updates := []Update{}
+	params := map[string]interface{}{
+		"offset":          offset,
+		"timeout":         pollTimeout,
+		"allowed_updates": []string{"message"},
+	}
+	err := b.call(ctx, "getUpdates", params, &updates)
+	return updates, err
+}
+
+// SendMessage sends plain text to a chat
+func (b *Bot) SendMessage(chatId string, text string) error {
+	params := map[string]interface{}{
+		"chat_id":                  chatId,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	}
+	return b.call(context.Background(), "sendMessage", params, nil)
+}
+
+// ListenForTelegramMessages polls for new messages and handles the bot's commands until ctx is done
+func ListenForTelegramMessages(ctx context.Context, bot *Bot, watchTransaction chan models.WatchTx, store *utils.WatchStore, notify utils.NotifyPolicy) {
+	var offset int64
+	for {
+		updates, err := bot.GetUpdates(ctx, offset)
+		if ctx.Err() != nil {
+			log.Println("Shutting down telegram listener")
+			return
+		}
+		if err != nil {
+			log.Printf("failed to get telegram updates: %s", err.Error())
+			wait := 5 * time.Second
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+				wait = time.Duration(apiErr.RetryAfter) * time.Second
+			}
+			select {
+			case <-ctx.Done():
+				log.Println("Shutting down telegram listener")
+				return
+			case <-time.After(wait):
+			}
+			continue
+		}
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+			if update.Message == nil {
+				continue
+			}
+			err := HandleMessage(update.Message, bot, watchTransaction, store, notify)
+			if err != nil {
+				log.Printf("failed to handle telegram message: %s", err.Error())
+			}
+		}
+	}
+}
+
+// commands the bot answers to
+const (
+	CommandStart   = "/start"
+	CommandHelp    = "/help"
+	CommandWatch   = "/watch"
+	CommandList    = "/list"
+	CommandUnwatch = "/unwatch"
+)
+
+const usage = `/watch <txid or address> [confirms] [network] [email] - watch a transaction, or an address for the first transaction paying it, until it has the confirmations (6 by default) on mainnet, testnet or signet, emailing the first confirmation and the final message to email when it is given
+/list - show what is being watched in this chat
+/unwatch <txid or address> - stop watching`
+
+// ParseCommand splits a message into its command and arguments, dropping the @botname telegram adds in groups
+func ParseCommand(text string) (string, []string) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return "", nil
+	}
+	command, _, _ := strings.Cut(fields[0], "@")
+	return strings.ToLower(command), fields[1:]
+}
+
+// ParseWatch reads the arguments of /watch, "<txid or address> [confirms] [network] [email]", the email has to be
+// allowed by notify
+func ParseWatch(args []string, notify utils.NotifyPolicy) (*models.WatchTx, error) {
+	if len(args) == 0 || len(args) > 4 {
+		return nil, errors.New("the format is /watch <txid or address> [confirms] [network] [email]")
+	}
+	watchTx := &models.WatchTx{
+		Confs:         6,
+		TimeRequested: time.Now().UTC().Unix(),
+	}
+	if isTxId(args[0]) {
+		watchTx.TxID = args[0]
+	} else {
+		watchTx.Address = args[0]
+	}
+	for _, arg := range args[1:] {
+		if confs, err := strconv.Atoi(arg); err == nil {
+			if confs <= 0 {
+				return nil, fmt.Errorf("confirms has to be at least 1, not %d", confs)
+			}
+			watchTx.Confs = confs
+			continue
+		}
+		if strings.Contains(arg, "@") {
+			addresses, err := utils.ParseNotify(arg, notify)
+			if err != nil {
+				return nil, err
+			}
+			watchTx.Notify = addresses
+			continue
+		}
+		switch network := strings.ToLower(arg); network {
+		case "mainnet":
+		case "testnet", "signet":
+			watchTx.Network = network
+		default:
+			return nil, fmt.Errorf("%s is not a number of confirmations or a network (mainnet, testnet or signet)", arg)
+		}
+	}
+	return watchTx, nil
+}
+
+// isTxId is true for 64 hex characters, anything else passed to /watch is taken to be an address
+func isTxId(id string) bool {
+	if len(id) != 64 {
+		return false
+	}
+	for _, c := range strings.ToLower(id) {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func HandleMessage(message *Message, bot *Bot, watchTransaction chan models.WatchTx, store *utils.WatchStore, notify utils.NotifyPolicy) error {
+	chatId := strconv.FormatInt(message.Chat.ID, 10)
+	command, args := ParseCommand(message.Text)
+	switch command {
+	case CommandWatch:
+		watchTx, errConv := ParseWatch(args, notify)
+		if errConv != nil {
+			return bot.SendMessage(chatId, fmt.Sprintf("Failed to setup watcher, check your format? %s", errConv))
+		}
+		watchTx.Channel = chatId
+		watchTx.Frontend = models.FrontendTelegram
+		watchTransaction <- *watchTx
+		return bot.SendMessage(chatId, utils.WatchingText(*watchTx))
+	case CommandList:
+		return HandleListCommand(chatId, bot, store)
+	case CommandUnwatch:
+		if len(args) != 1 {
+			return bot.SendMessage(chatId, "Failed to stop watching, the format is /unwatch <txid or address>")
+		}
+		return HandleUnwatch(chatId, args[0], bot, store)
+	case CommandStart, CommandHelp:
+		return bot.SendMessage(chatId, usage)
+	}
+	return nil
+}
+
+func HandleListCommand(chatId string, bot *Bot, store *utils.WatchStore) error {
+	return bot.SendMessage(chatId, utils.ListText(store, models.FrontendTelegram, chatId))
+}
+
+func HandleUnwatch(chatId string, id string, bot *Bot, store *utils.WatchStore) error {
+	removed := utils.RemoveWatches(store, models.FrontendTelegram, chatId, id)
+	if len(removed) == 0 {
+		return bot.SendMessage(chatId, utils.NotWatchedText(models.FrontendTelegram, id))
+	}
+	return bot.SendMessage(chatId, utils.UnwatchedText(id))
+}
@@ -0,0 +1,193 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"tx-tracker/pkg/models"
+	"tx-tracker/pkg/utils"
+)
+
+const testTxId = "1111111111111111111111111111111111111111111111111111111111111111"
+
+// sentMessage is a sendMessage call made to the stub
+type sentMessage struct {
+	ChatId string `json:"chat_id"`
+	Text   string `json:"text"`
+}
+
+// stubBotAPI serves getUpdates and sendMessage, the first getUpdates gets a batch of commands and the later ones
+// nothing until the request is cancelled
+type stubBotAPI struct {
+	t       *testing.T
+	offsets chan int64
+	sent    chan sentMessage
+}
+
+func newStubBotAPI(t *testing.T) (*Bot, *stubBotAPI) {
+	stub := &stubBotAPI{t: t, offsets: make(chan int64, 10), sent: make(chan sentMessage, 10)}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	bot := NewBot("123:token")
+	bot.apiUrl = server.URL
+	return bot, stub
+}
+
+func (s *stubBotAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/bot123:token/getUpdates":
+		params := struct {
+			Offset  int64 `json:"offset"`
+			Timeout int   `json:"timeout"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			s.t.Errorf("failed to decode getUpdates: %s", err)
+		}
+		if params.Timeout != pollTimeout {
+			s.t.Errorf("got a timeout of %d, want the long poll of %d", params.Timeout, pollTimeout)
+		}
+		s.offsets <- params.Offset
+		if params.Offset == 0 {
+			w.Write([]byte(`{"ok":true,"result":[
+				{"update_id":41,"message":{"message_id":1,"chat":{"id":-100},"text":"/list@tx_tracker_bot"}},
+				{"update_id":42,"edited_message":{"message_id":1,"chat":{"id":-100},"text":"/list"}},
+				{"update_id":43,"message":{"message_id":2,"chat":{"id":-100},"text":"/watch ` + testTxId + ` 2 testnet"}},
+				{"update_id":44,"message":{"message_id":3,"chat":{"id":-100},"text":"/unwatch bc1qnotwatched"}},
+				{"update_id":45,"message":{"message_id":4,"chat":{"id":-100},"text":"just chatting"}}
+			]}`))
+			return
+		}
+		<-r.Context().Done()
+	case "/bot123:token/sendMessage":
+		message := sentMessage{}
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			s.t.Errorf("failed to decode sendMessage: %s", err)
+		}
+		s.sent <- message
+		w.Write([]byte(`{"ok":true,"result":{"message_id":10,"chat":{"id":-100}}}`))
+	default:
+		s.t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"ok":false,"error_code":404,"description":"Not Found"}`))
+	}
+}
+
+func (s *stubBotAPI) nextSent(t *testing.T) sentMessage {
+	t.Helper()
+	select {
+	case message := <-s.sent:
+		return message
+	case <-time.After(time.Second * 5):
+		t.Fatalf("nothing was sent")
+	}
+	return sentMessage{}
+}
+
+func TestListenForTelegramMessages(t *testing.T) {
+	bot, stub := newStubBotAPI(t)
+	store := utils.NewWatchStore()
+	watchTransaction := make(chan models.WatchTx, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ListenForTelegramMessages(ctx, bot, watchTransaction, store, utils.NotifyPolicy{})
+		close(done)
+	}()
+
+	if got := stub.nextSent(t); got != (sentMessage{ChatId: "-100", Text: "Nothing is being watched in this chat"}) {
+		t.Errorf("got %+v for /list", got)
+	}
+	watching := stub.nextSent(t)
+	if !strings.HasPrefix(watching.Text, "Your transaction "+testTxId+" on testnet is being watched") {
+		t.Errorf("got %+v for /watch", watching)
+	}
+	watchTx := <-watchTransaction
+	if watchTx.TxID != testTxId || watchTx.Confs != 2 || watchTx.Network != "testnet" || watchTx.Channel != "-100" || watchTx.Frontend != models.FrontendTelegram {
+		t.Errorf("got %+v, want the watch on the txid from the chat", watchTx)
+	}
+	if got := stub.nextSent(t); got.Text != utils.NotWatchedText(models.FrontendTelegram, "bc1qnotwatched") {
+		t.Errorf("got %+v for /unwatch", got)
+	}
+
+	//the next poll acknowledges the batch, including the updates that weren't commands
+	offsets := []int64{<-stub.offsets, <-stub.offsets}
+	if !reflect.DeepEqual(offsets, []int64{0, 46}) {
+		t.Errorf("got offsets %v, want 0 then 46", offsets)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("the listener didn't stop when ctx was done")
+	}
+	select {
+	case message := <-stub.sent:
+		t.Errorf("got %+v, want no reply to chatter", message)
+	default:
+	}
+}
+
+func TestCallReturnsAPIErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 7","parameters":{"retry_after":7}}`))
+	}))
+	defer server.Close()
+	bot := NewBot("123:token")
+	bot.apiUrl = server.URL
+
+	err := bot.SendMessage("-100", "hello")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode != 429 || apiErr.RetryAfter != 7 || apiErr.Method != "sendMessage" {
+		t.Errorf("got %v, want the rate limit error", err)
+	}
+	if strings.Contains(fmt.Sprint(err), "token") {
+		t.Errorf("the error %q shows the bot token", err)
+	}
+}
+
+func TestParseWatch(t *testing.T) {
+	notify := utils.NotifyPolicy{Enabled: true, Domains: []string{"example.com"}}
+	tests := []struct {
+		args    []string
+		want    models.WatchTx
+		wantErr bool
+	}{
+		{args: []string{testTxId}, want: models.WatchTx{TxID: testTxId, Confs: 6}},
+		{args: []string{"bc1qaddress", "3", "signet"}, want: models.WatchTx{Address: "bc1qaddress", Confs: 3, Network: "signet"}},
+		{args: []string{testTxId, "mainnet", "1"}, want: models.WatchTx{TxID: testTxId, Confs: 1}},
+		{args: []string{testTxId, "2", "ops@example.com,Finance@EXAMPLE.com"}, want: models.WatchTx{TxID: testTxId, Confs: 2, Notify: "ops@example.com,Finance@EXAMPLE.com"}},
+		{args: []string{testTxId, "ops@example.org"}, wantErr: true},
+		{args: []string{testTxId, "0"}, wantErr: true},
+		{args: []string{testTxId, "regtest"}, wantErr: true},
+		{args: []string{}, wantErr: true},
+	}
+	for _, test := range tests {
+		watchTx, err := ParseWatch(test.args, notify)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseWatch(%q) = %+v, want an error", test.args, watchTx)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseWatch(%q) failed: %s", test.args, err)
+			continue
+		}
+		watchTx.TimeRequested = 0
+		if *watchTx != test.want {
+			t.Errorf("ParseWatch(%q) = %+v, want %+v", test.args, *watchTx, test.want)
+		}
+	}
+	if watchTx, err := ParseWatch([]string{testTxId, "ops@example.com"}, utils.NotifyPolicy{}); err == nil {
+		t.Errorf("got %+v, want notify turned down without a mailer", watchTx)
+	}
+}
@@ -0,0 +1,17 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// HashBlockHeader returns the block hash of a serialized 80 byte block header, in the reversed byte order used by
+// bitcoind's rpc and electrum servers
+func HashBlockHeader(header []byte) string {
+	first := sha256.Sum256(header)
+	second := sha256.Sum256(first[:])
+	for i, j := 0, len(second)-1; i < j; i, j = i+1, j-1 {
+		second[i], second[j] = second[j], second[i]
+	}
+	return hex.EncodeToString(second[:])
+}
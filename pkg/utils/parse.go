@@ -0,0 +1,319 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"tx-tracker/pkg/models"
+)
+
+// commands understood in a mention, anything else is treated as a request to watch
+const (
+	CommandWatch   = "watch"
+	CommandList    = "list"
+	CommandUnwatch = "unwatch"
+	CommandUpdate  = "update"
+)
+
+// ParseCommand returns the command of a mention, the first word after the bot's @mention
+func ParseCommand(rawMessage string) string {
+	for _, word := range strings.Fields(rawMessage) {
+		if strings.HasPrefix(word, "<@") || strings.HasPrefix(word, "@") {
+			continue
+		}
+		switch command := strings.ToLower(word); command {
+		case CommandList, CommandUnwatch, CommandUpdate:
+			return command
+		}
+		break
+	}
+	return CommandWatch
+}
+
+func ParseMessage(rawMessage string) (*models.WatchTx, error) {
+
+	transactionMatch, errTransRegex := regexp.Compile("(txId: [0-9|a-z|A-Z]+)")
+	if errTransRegex != nil {
+		return nil, errTransRegex
+	}
+	confirmsMatch, errConfRegex := regexp.Compile("(confirms: -?[0-9|a-z|A-Z]+)")
+	if errConfRegex != nil {
+		return nil, errConfRegex
+	}
+	networkMatch, errNetworkReges := regexp.Compile("(network: [a-z|A-Z]+)")
+	if errNetworkReges != nil {
+		return nil, errNetworkReges
+	}
+	addressMatch, errAddressRegex := regexp.Compile("(address: [0-9|a-z|A-Z]+)")
+	if errAddressRegex != nil {
+		return nil, errAddressRegex
+	}
+	notifyMatch, errNotifyRegex := regexp.Compile(`notify: ([^\s,]+(?:\s*,\s*[^\s,]+)*)`)
+	if errNotifyRegex != nil {
+		return nil, errNotifyRegex
+	}
+	transactionText := transactionMatch.Find([]byte(rawMessage))
+
+	var txId *string
+	if transactionText != nil {
+		rawId := strings.Split(string(transactionText), ": ")[1]
+		txId = &rawId
+	}
+	addressText := addressMatch.Find([]byte(rawMessage))
+
+	var address string = ""
+	if addressText != nil {
+		address = strings.Split(string(addressText), ": ")[1]
+	}
+	networkText := networkMatch.Find([]byte(rawMessage))
+
+	var network string = ""
+	if networkText != nil {
+		rawNetwork := strings.Split(string(networkText), ": ")[1]
+		network = rawNetwork
+	}
+	notifyText := notifyMatch.FindSubmatch([]byte(rawMessage))
+
+	var notify string = ""
+	if notifyText != nil {
+		parsedNotify, err := notifyAddresses(string(notifyText[1]))
+		if err != nil {
+			return nil, err
+		}
+		notify = parsedNotify
+	}
+	confirmText := confirmsMatch.Find([]byte(rawMessage))
+
+	var confirms *int
+	if confirmText != nil {
+		rawConfirms := strings.Split(string(confirmText), ": ")[1]
+		convConfirms, err := strconv.Atoi(rawConfirms)
+		if err != nil {
+			return nil, err
+		}
+		if convConfirms < 1 {
+			return nil, fmt.Errorf("confirms has to be at least 1, not %d", convConfirms)
+		}
+		confirms = &convConfirms
+	}
+
+	if txId != nil || len(address) > 0 {
+		if txId == nil {
+			//the txId is filled in once a transaction paying the address is seen
+			emptyId := ""
+			txId = &emptyId
+		}
+		if confirms == nil {
+			defaultCount := 6
+			confirms = &defaultCount
+		}
+		timeRequest := time.Now().UTC().Unix()
+		return &models.WatchTx{
+			TxID:               *txId,
+			Address:            address,
+			Confs:              *confirms,
+			ConfsCount:         0,
+			Network:            network,
+			Notify:             notify,
+			ConfirmBlockHeight: 0,
+			TimeRequested:      timeRequest,
+		}, nil
+	} else {
+		return nil, fmt.Errorf("a txId or address is required, in the format: 'txId: <transacitonId to watch>' or 'address: <address to watch>'")
+	}
+}
+
+// ParseWatch parses a request for a new watch, which can only ask to notify the addresses policy allows
+func ParseWatch(rawMessage string, policy NotifyPolicy) (*models.WatchTx, error) {
+	watchTx, err := ParseMessage(rawMessage)
+	if err != nil {
+		return nil, err
+	}
+	err = policy.Check(watchTx.Notify)
+	if err != nil {
+		return nil, err
+	}
+	return watchTx, nil
+}
+
+// ParseNotify checks a comma separated list of email addresses can be notified under policy, returning them
+// joined by ","
+func ParseNotify(raw string, policy NotifyPolicy) (string, error) {
+	notify, err := notifyAddresses(raw)
+	if err != nil {
+		return "", err
+	}
+	err = policy.Check(notify)
+	if err != nil {
+		return "", err
+	}
+	return notify, nil
+}
+
+// notifyAddresses checks a comma separated list is of email addresses, returning them joined by ",". Slack turns
+// addresses into "<mailto:a@example.com|a@example.com>" links before they reach the bot, those are unwrapped.
+func notifyAddresses(raw string) (string, error) {
+	addresses := []string{}
+	for _, address := range strings.Split(raw, ",") {
+		address = strings.TrimSpace(address)
+		if strings.HasPrefix(address, "<mailto:") {
+			address = strings.TrimSuffix(strings.TrimPrefix(address, "<mailto:"), ">")
+			address, _, _ = strings.Cut(address, "|")
+		}
+		if len(address) == 0 {
+			continue
+		}
+		parsed, err := mail.ParseAddress(address)
+		if err != nil || parsed.Address != address {
+			return "", fmt.Errorf("%s is not an email address, in the format: 'notify: <email>[,<email>]'", address)
+		}
+		addresses = append(addresses, parsed.Address)
+	}
+	return strings.Join(addresses, ","), nil
+}
+
+// NotifyPolicy is who can be emailed with notify:, the zero value turns notify: down as there's no mailer to send
+// the emails
+type NotifyPolicy struct {
+	// Enabled is set when a mailer is configured
+	Enabled bool
+	// Domains are the email domains that can be notified, unless AnyDomain allows every domain
+	Domains   []string
+	AnyDomain bool
+}
+
+// NewNotifyPolicy enables notify: for a comma separated list of email domains, "*" allows any domain. The list
+// can't be empty, leaving it out would let the bot be used to email anyone.
+func NewNotifyPolicy(rawDomains string) (NotifyPolicy, error) {
+	if strings.TrimSpace(rawDomains) == "*" {
+		return NotifyPolicy{Enabled: true, AnyDomain: true}, nil
+	}
+	domains := ParseDomains(rawDomains)
+	if len(domains) == 0 {
+		return NotifyPolicy{}, errors.New("no email domains are allowed, list them or use * to allow any")
+	}
+	return NotifyPolicy{Enabled: true, Domains: domains}, nil
+}
+
+// Allows returns whether the email address can be notified
+func (p NotifyPolicy) Allows(address string) bool {
+	if !p.Enabled {
+		return false
+	}
+	if p.AnyDomain {
+		return true
+	}
+	domain := strings.ToLower(address[strings.LastIndex(address, "@")+1:])
+	for _, allowed := range p.Domains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns why the addresses in a watch's notify can't be notified, nil when they all can
+func (p NotifyPolicy) Check(notify string) error {
+	if len(notify) == 0 {
+		return nil
+	}
+	if !p.Enabled {
+		return errors.New("notify: can't be used, no mail server is configured")
+	}
+	for _, address := range strings.Split(notify, ",") {
+		if !p.Allows(address) {
+			return fmt.Errorf("%s can't be notified, only addresses at %s are allowed", address, strings.Join(p.Domains, ", "))
+		}
+	}
+	return nil
+}
+
+// ParseDomains reads a comma separated list of email domains, ie "example.com, @example.org"
+func ParseDomains(raw string) []string {
+	domains := []string{}
+	for _, domain := range strings.Split(raw, ",") {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+		if len(domain) > 0 {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// ParseUpdate parses an update command, which has to give the new target
+func ParseUpdate(rawMessage string) (*models.WatchTx, error) {
+	watchTx, errConv := ParseMessage(rawMessage)
+	if errConv == nil && !strings.Contains(rawMessage, "confirms: ") {
+		errConv = errors.New("a new target is required, in the format: 'confirms: <number of confirmations>'")
+	}
+	return watchTx, errConv
+}
+
+// UpdatedText is the reply to changing the target of the watch on id to confs, confsCount is how many it has
+func UpdatedText(id string, confs int, confsCount int) string {
+	if confsCount >= confs {
+		return fmt.Sprintf("%s already has %d confirmations, which meets the new target of %d, so it is no longer watched", id, confsCount, confs)
+	}
+	return fmt.Sprintf("%s will now be watched until %d confirmations have occured, it currently has %d", id, confs, confsCount)
+}
+
+// channelNoun is what the frontend calls the channels watches are requested from
+func channelNoun(frontend string) string {
+	switch NormalizeFrontend(frontend) {
+	case models.FrontendTelegram:
+		return "chat"
+	case models.FrontendMatrix:
+		return "room"
+	}
+	return "channel"
+}
+
+// ListText is the reply to the list command, describing every watch in a channel of the frontend
+func ListText(store *WatchStore, frontend string, channel string) string {
+	noun := channelNoun(frontend)
+	watches := WatchesForChannel(store, frontend, channel)
+	if len(watches) == 0 {
+		return fmt.Sprintf("Nothing is being watched in this %s", noun)
+	}
+	now := time.Now().UTC()
+	lines := []string{fmt.Sprintf("Watching %d transactions in this %s:", len(watches), noun)}
+	for _, watchTx := range watches {
+		lines = append(lines, "• "+DescribeWatch(watchTx, now))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// NotWatchedText is the reply to a command on id when the channel of the frontend isn't watching it
+func NotWatchedText(frontend string, id string) string {
+	return fmt.Sprintf("%s is not being watched in this %s", id, channelNoun(frontend))
+}
+
+// UnwatchedText is the reply to stopping the watches on id
+func UnwatchedText(id string) string {
+	return fmt.Sprintf("Stopped watching %s, you will no longer be notified", id)
+}
+
+// WatchSubject returns the txid a watch is on, or the address while it is waiting for a payment
+func WatchSubject(watchTx models.WatchTx) string {
+	if len(watchTx.TxID) == 0 {
+		return watchTx.Address
+	}
+	return watchTx.TxID
+}
+
+// WatchingText is the reply to a new watch
+func WatchingText(watchTx models.WatchTx) string {
+	network := NormalizeNetwork(watchTx.Network)
+	emailed := ""
+	if len(watchTx.Notify) > 0 {
+		emailed = fmt.Sprintf(", %s will be emailed when it first confirms and when it reaches the target", strings.ReplaceAll(watchTx.Notify, ",", ", "))
+	}
+	if len(watchTx.Address) > 0 {
+		return fmt.Sprintf("The address %s on %s is being watched, you will be notified when a transaction paying it hits the mempool and of each block until %d confirmations have occured%s", watchTx.Address, network, watchTx.Confs, emailed)
+	}
+	return fmt.Sprintf("Your transaction %s on %s is being watched and you will be notified of each block until %d confirmations have occured%s", watchTx.TxID, network, watchTx.Confs, emailed)
+}
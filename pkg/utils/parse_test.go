@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"tx-tracker/pkg/models"
+)
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		message     string
+		wantTxID    string
+		wantAddress string
+		wantConfs   int
+		wantNetwork string
+		wantErr     bool
+	}{
+		{message: "<@U1> txId: abc123", wantTxID: "abc123", wantConfs: 6},
+		{message: "<@U1> txId: abc123 confirms: 2 network: testnet", wantTxID: "abc123", wantConfs: 2, wantNetwork: "testnet"},
+		//an address watch has no txid until a payment to it is seen
+		{message: "<@U1> address: bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq", wantAddress: "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq", wantConfs: 6},
+		{message: "address: tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx confirms: 1 network: testnet", wantAddress: "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", wantConfs: 1, wantNetwork: "testnet"},
+		{message: "<@U1> confirms: 3", wantErr: true},
+		{message: "<@U1> txId: abc123 confirms: many", wantErr: true},
+		{message: "<@U1> txId: abc123 confirms: 0", wantErr: true},
+		{message: "<@U1> address: bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq confirms: -1", wantErr: true},
+	}
+	for _, test := range tests {
+		watchTx, err := ParseMessage(test.message)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseMessage(%q) = %+v, want an error", test.message, watchTx)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseMessage(%q) failed: %s", test.message, err)
+			continue
+		}
+		if watchTx.TxID != test.wantTxID || watchTx.Address != test.wantAddress || watchTx.Confs != test.wantConfs || watchTx.Network != test.wantNetwork {
+			t.Errorf("ParseMessage(%q) = txid %q, address %q, confs %d, network %q, want %q, %q, %d, %q", test.message,
+				watchTx.TxID, watchTx.Address, watchTx.Confs, watchTx.Network, test.wantTxID, test.wantAddress, test.wantConfs, test.wantNetwork)
+		}
+	}
+}
+
+func TestParseUpdate(t *testing.T) {
+	tests := []struct {
+		message   string
+		wantConfs int
+		wantErr   bool
+	}{
+		{message: "<@U1> update txId: abc123 confirms: 3", wantConfs: 3},
+		{message: "<@U1> update txId: abc123 confirms: 1", wantConfs: 1},
+		//without a target the default of 6 would silently be applied
+		{message: "<@U1> update txId: abc123", wantErr: true},
+		{message: "<@U1> update txId: abc123 confirms: 0", wantErr: true},
+		{message: "<@U1> update txId: abc123 confirms: -2", wantErr: true},
+		{message: "<@U1> update confirms: 3", wantErr: true},
+	}
+	for _, test := range tests {
+		watchTx, err := ParseUpdate(test.message)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseUpdate(%q) = %+v, want an error", test.message, watchTx)
+			}
+			continue
+		}
+		if err != nil || watchTx.Confs != test.wantConfs {
+			t.Errorf("ParseUpdate(%q) = %+v, %v, want confs %d", test.message, watchTx, err, test.wantConfs)
+		}
+	}
+}
+
+func TestParseNotify(t *testing.T) {
+	policy := NotifyPolicy{Enabled: true, Domains: []string{"example.com"}}
+	tests := []struct {
+		raw     string
+		policy  NotifyPolicy
+		want    string
+		wantErr bool
+	}{
+		{raw: "ops@example.com", policy: policy, want: "ops@example.com"},
+		{raw: "ops@example.com , finance@Example.COM", policy: policy, want: "ops@example.com,finance@Example.COM"},
+		//slack links the addresses before they reach the bot
+		{raw: "<mailto:ops@example.com|ops@example.com>,<mailto:finance@example.com|finance@example.com>", policy: policy, want: "ops@example.com,finance@example.com"},
+		{raw: "ops@example.org", policy: NotifyPolicy{Enabled: true, AnyDomain: true}, want: "ops@example.org"},
+		{raw: "ops@example.org", policy: policy, wantErr: true},
+		{raw: "ops@example.com,ops@example.org", policy: policy, wantErr: true},
+		{raw: "ops@example.com", policy: NotifyPolicy{}, wantErr: true},
+		{raw: "not an address", policy: policy, wantErr: true},
+	}
+	for _, test := range tests {
+		notify, err := ParseNotify(test.raw, test.policy)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseNotify(%q, %+v) = %q, want an error", test.raw, test.policy, notify)
+			}
+			continue
+		}
+		if err != nil || notify != test.want {
+			t.Errorf("ParseNotify(%q, %+v) = %q, %v, want %q", test.raw, test.policy, notify, err, test.want)
+		}
+	}
+}
+
+func TestNewNotifyPolicy(t *testing.T) {
+	policy, err := NewNotifyPolicy(" @Example.com, example.org ")
+	if err != nil || !policy.Enabled || policy.AnyDomain || !policy.Allows("ops@EXAMPLE.COM") || policy.Allows("ops@example.net") {
+		t.Errorf("got %+v, %v, want example.com and example.org allowed", policy, err)
+	}
+	if policy, err := NewNotifyPolicy("*"); err != nil || !policy.AnyDomain || !policy.Allows("ops@example.net") {
+		t.Errorf("got %+v, %v, want any domain allowed", policy, err)
+	}
+	//an empty list would let the bot email anyone
+	if policy, err := NewNotifyPolicy(" "); err == nil {
+		t.Errorf("got %+v, want an error without an allowlist", policy)
+	}
+}
+
+func TestParseWatch(t *testing.T) {
+	message := "<@U1> txId: abc123 notify: <mailto:ops@example.com|ops@example.com>"
+	if _, err := ParseMessage(message); err != nil {
+		t.Fatalf("ParseMessage(%q) failed: %s", message, err)
+	}
+	if watchTx, err := ParseWatch(message, NotifyPolicy{}); err == nil {
+		t.Errorf("got %+v, want notify turned down without a mailer", watchTx)
+	}
+	watchTx, err := ParseWatch(message, NotifyPolicy{Enabled: true, Domains: []string{"example.com"}})
+	if err != nil || watchTx.Notify != "ops@example.com" {
+		t.Errorf("got %+v, %v, want ops@example.com notified", watchTx, err)
+	}
+	if _, err := ParseWatch("<@U1> txId: abc123", NotifyPolicy{}); err != nil {
+		t.Errorf("a watch without notify: failed without a mailer: %s", err)
+	}
+}
+
+func TestListText(t *testing.T) {
+	store := NewWatchStore()
+	now := time.Now().UTC().Unix()
+	store.Add(models.WatchTx{TxID: "aa", Channel: "C1", Confs: 6, TimeRequested: now})
+	store.Add(models.WatchTx{Address: "bc1qaddress", Channel: "C1", Confs: 3, TimeRequested: now + 1})
+	store.Add(models.WatchTx{TxID: "bb", Channel: "C1", Frontend: models.FrontendDiscord, Confs: 6, TimeRequested: now})
+
+	got := ListText(store, models.FrontendSlack, "C1")
+	lines := strings.Split(got, "\n")
+	if len(lines) != 3 || lines[0] != "Watching 2 transactions in this channel:" || !strings.Contains(lines[1], "aa") || !strings.Contains(lines[2], "bc1qaddress") {
+		t.Errorf("got %q, want the two slack watches oldest first", got)
+	}
+	if got := ListText(store, models.FrontendMatrix, "C1"); got != "Nothing is being watched in this room" {
+		t.Errorf("got %q for a room without watches", got)
+	}
+}
+
+func TestNotWatchedText(t *testing.T) {
+	tests := map[string]string{
+		"":                      "aa is not being watched in this channel",
+		models.FrontendDiscord:  "aa is not being watched in this channel",
+		models.FrontendTelegram: "aa is not being watched in this chat",
+		models.FrontendMatrix:   "aa is not being watched in this room",
+	}
+	for frontend, want := range tests {
+		if got := NotWatchedText(frontend, "aa"); got != want {
+			t.Errorf("NotWatchedText(%q) = %q, want %q", frontend, got, want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// SnapshotSettle is how long the Snapshotter waits after a change for the rest of its batch before saving
+const SnapshotSettle = 2 * time.Second
+
+// Snapshotter keeps the save file up to date for the file storage, saving the store after each batch of changes
+// and on an interval
+type Snapshotter struct {
+	filename string
+	store    *WatchStore
+	mutex    sync.Mutex
+	saved    uint64
+}
+
+func NewSnapshotter(filename string, store *WatchStore) *Snapshotter {
+	return &Snapshotter{
+		filename: filename,
+		store:    store,
+		saved:    store.Version(),
+	}
+}
+
+// Save writes the store to the save file unless nothing changed since the last save
+func (s *Snapshotter) Save() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	version := s.store.Version()
+	if version == s.saved {
+		return nil
+	}
+	err := Save(s.filename, s.store)
+	if err != nil {
+		return err
+	}
+	s.saved = version
+	return nil
+}
+
+// Run saves SnapshotSettle after the store changes and every interval until ctx is done, an interval of zero
+// only saves after changes
+func (s *Snapshotter) Run(ctx context.Context, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.store.Changed():
+			if settle == nil {
+				settle = time.After(SnapshotSettle)
+			}
+		case <-settle:
+			settle = nil
+			s.saveAndLog()
+		case <-tick:
+			s.saveAndLog()
+		}
+	}
+}
+
+func (s *Snapshotter) saveAndLog() {
+	err := s.Save()
+	if err != nil {
+		log.Printf("\nfailed to snapshot watches to %s: %s", s.filename, err.Error())
+	}
+}
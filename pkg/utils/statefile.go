@@ -0,0 +1,258 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"tx-tracker/pkg/models"
+)
+
+// StateMagic marks a save file written with a StateHeader, files from before the header are version 1
+const StateMagic = "tx-tracker-state"
+
+// StateVersion is the version of the save file written by Save. Fields can be added to watchTxV2 as gob leaves
+// the ones missing from older files zero, when one is renamed or changes meaning bump the version, freeze the new
+// layout and add a reader to stateReaders that migrates the old one
+const StateVersion = 2
+
+// StateHeader is written ahead of the watches in the save file
+type StateHeader struct {
+	Magic   string
+	Version int
+}
+
+// State is the content of a save file, it is also the layout of the JSON used by the state export/import command
+type State struct {
+	Version int              `json:"version"`
+	Watches []models.WatchTx `json:"watches"`
+}
+
+// watchTxV1 is the WatchTx of the first release, the version 1 save file is a gob map[watchTxV1]string of them
+type watchTxV1 struct {
+	TxID               string
+	Confs              int
+	Network            string
+	Channel            string
+	ConfsCount         int
+	ConfirmBlockHeight int
+	TimeRequested      int64
+}
+
+// watchTx migrates the watch, its id is filled in from the watch when it is added back to the store
+func (w watchTxV1) watchTx() models.WatchTx {
+	return models.WatchTx{
+		TxID:               w.TxID,
+		Confs:              w.Confs,
+		Network:            w.Network,
+		Channel:            w.Channel,
+		ConfsCount:         w.ConfsCount,
+		ConfirmBlockHeight: w.ConfirmBlockHeight,
+		TimeRequested:      w.TimeRequested,
+	}
+}
+
+// watchTxV2 is a watch in the version 2 save file, kept apart from models.WatchTx so changing that doesn't
+// silently change the file
+type watchTxV2 struct {
+	ID                 string
+	TxID               string
+	Address            string
+	WatchedFromHeight  int
+	Amount             int64
+	Confs              int
+	Network            string
+	Channel            string
+	Frontend           string
+	User               string
+	ThreadID           string
+	StatusMessageID    string
+	Notify             string
+	ConfsCount         int
+	ConfirmBlockHeight int
+	ConfirmBlockHash   string
+	ConfirmBlockTime   int64
+	ConfirmBlockPool   string
+	TimeRequested      int64
+	ExpiresAt          int64
+	State              string
+	Fee                int64
+	VSize              int
+	Inputs             string
+	ReplacedBy         string
+}
+
+func toWatchTxV2(w models.WatchTx) watchTxV2 {
+	return watchTxV2{
+		ID:                 w.ID,
+		TxID:               w.TxID,
+		Address:            w.Address,
+		WatchedFromHeight:  w.WatchedFromHeight,
+		Amount:             w.Amount,
+		Confs:              w.Confs,
+		Network:            w.Network,
+		Channel:            w.Channel,
+		Frontend:           w.Frontend,
+		User:               w.User,
+		ThreadID:           w.ThreadID,
+		StatusMessageID:    w.StatusMessageID,
+		Notify:             w.Notify,
+		ConfsCount:         w.ConfsCount,
+		ConfirmBlockHeight: w.ConfirmBlockHeight,
+		ConfirmBlockHash:   w.ConfirmBlockHash,
+		ConfirmBlockTime:   w.ConfirmBlockTime,
+		ConfirmBlockPool:   w.ConfirmBlockPool,
+		TimeRequested:      w.TimeRequested,
+		ExpiresAt:          w.ExpiresAt,
+		State:              w.State,
+		Fee:                w.Fee,
+		VSize:              w.VSize,
+		Inputs:             w.Inputs,
+		ReplacedBy:         w.ReplacedBy,
+	}
+}
+
+func (w watchTxV2) watchTx() models.WatchTx {
+	return models.WatchTx{
+		ID:                 w.ID,
+		TxID:               w.TxID,
+		Address:            w.Address,
+		WatchedFromHeight:  w.WatchedFromHeight,
+		Amount:             w.Amount,
+		Confs:              w.Confs,
+		Network:            w.Network,
+		Channel:            w.Channel,
+		Frontend:           w.Frontend,
+		User:               w.User,
+		ThreadID:           w.ThreadID,
+		StatusMessageID:    w.StatusMessageID,
+		Notify:             w.Notify,
+		ConfsCount:         w.ConfsCount,
+		ConfirmBlockHeight: w.ConfirmBlockHeight,
+		ConfirmBlockHash:   w.ConfirmBlockHash,
+		ConfirmBlockTime:   w.ConfirmBlockTime,
+		ConfirmBlockPool:   w.ConfirmBlockPool,
+		TimeRequested:      w.TimeRequested,
+		ExpiresAt:          w.ExpiresAt,
+		State:              w.State,
+		Fee:                w.Fee,
+		VSize:              w.VSize,
+		Inputs:             w.Inputs,
+		ReplacedBy:         w.ReplacedBy,
+	}
+}
+
+// stateReaders decode the watches of each save file version into the current WatchTx
+var stateReaders = map[int]func(decoder *gob.Decoder) ([]models.WatchTx, error){
+	1: readStateV1,
+	2: readStateV2,
+}
+
+// readStateV1 reads the original headerless file, a map[watchTxV1]string keyed by the watches
+func readStateV1(decoder *gob.Decoder) ([]models.WatchTx, error) {
+	rawDic := make(map[watchTxV1]string, 0)
+	err := decoder.Decode(&rawDic)
+	if err != nil {
+		return nil, err
+	}
+	watches := make([]models.WatchTx, 0, len(rawDic))
+	for watchTx := range rawDic {
+		watches = append(watches, watchTx.watchTx())
+	}
+	return watches, nil
+}
+
+func readStateV2(decoder *gob.Decoder) ([]models.WatchTx, error) {
+	stored := []watchTxV2{}
+	err := decoder.Decode(&stored)
+	if err != nil {
+		return nil, err
+	}
+	watches := make([]models.WatchTx, 0, len(stored))
+	for _, watchTx := range stored {
+		watches = append(watches, watchTx.watchTx())
+	}
+	return watches, nil
+}
+
+// ReadState reads a gzipped save file of any version, migrating it to the current version
+func ReadState(r io.Reader) (State, error) {
+	fz, err := gzip.NewReader(r)
+	if err != nil {
+		return State{}, err
+	}
+	defer fz.Close()
+	raw, err := io.ReadAll(fz)
+	if err != nil {
+		return State{}, err
+	}
+
+	version := 1
+	decoder := gob.NewDecoder(bytes.NewReader(raw))
+	header := StateHeader{}
+	if errHeader := decoder.Decode(&header); errHeader == nil && header.Magic == StateMagic {
+		version = header.Version
+	} else {
+		decoder = gob.NewDecoder(bytes.NewReader(raw))
+	}
+
+	reader, ok := stateReaders[version]
+	if !ok {
+		return State{}, fmt.Errorf("save file version %d is not supported, this build reads up to version %d", version, StateVersion)
+	}
+	watches, err := reader(decoder)
+	if err != nil {
+		return State{}, fmt.Errorf("failed to read version %d save file: %w", version, err)
+	}
+	return State{Version: StateVersion, Watches: watches}, nil
+}
+
+// WriteState writes state as a gzipped save file at the current version
+func WriteState(w io.Writer, state State) error {
+	fz := gzip.NewWriter(w)
+	encoder := gob.NewEncoder(fz)
+	err := encoder.Encode(StateHeader{Magic: StateMagic, Version: StateVersion})
+	if err != nil {
+		return err
+	}
+	watches := make([]watchTxV2, 0, len(state.Watches))
+	for _, watchTx := range state.Watches {
+		watches = append(watches, toWatchTxV2(watchTx))
+	}
+	err = encoder.Encode(watches)
+	if err != nil {
+		return err
+	}
+	return fz.Close()
+}
+
+// StateOf returns the watches in the store as a State
+func StateOf(store *WatchStore) State {
+	return State{Version: StateVersion, Watches: store.All()}
+}
+
+// ExportState writes state as indented JSON
+func ExportState(w io.Writer, state State) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(state)
+}
+
+// ImportState reads the JSON written by ExportState, a missing version is taken to be the current one
+func ImportState(r io.Reader) (State, error) {
+	state := State{}
+	err := json.NewDecoder(r).Decode(&state)
+	if err != nil {
+		return State{}, err
+	}
+	if state.Version == 0 {
+		state.Version = StateVersion
+	}
+	if state.Version > StateVersion {
+		return State{}, fmt.Errorf("state version %d is newer than this build supports (%d)", state.Version, StateVersion)
+	}
+	state.Version = StateVersion
+	return state, nil
+}
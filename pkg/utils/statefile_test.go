@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"reflect"
+	"sort"
+	"testing"
+
+	"tx-tracker/pkg/models"
+)
+
+// v1Fixture writes a version 1 save file the way the first release did
+func v1Fixture(t *testing.T, watches ...watchTxV1) *bytes.Buffer {
+	rawDic := make(map[watchTxV1]string)
+	for _, watchTx := range watches {
+		rawDic[watchTx] = watchTx.TxID
+	}
+	var file bytes.Buffer
+	fz := gzip.NewWriter(&file)
+	if err := gob.NewEncoder(fz).Encode(rawDic); err != nil {
+		t.Fatal(err)
+	}
+	if err := fz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &file
+}
+
+func TestReadStateV1(t *testing.T) {
+	file := v1Fixture(t,
+		watchTxV1{TxID: "aa", Confs: 6, Channel: "C1", ConfsCount: 2, ConfirmBlockHeight: 800000, TimeRequested: 1690000000},
+		watchTxV1{TxID: "bb", Confs: 1, Network: "testnet", Channel: "C2", TimeRequested: 1690000100},
+	)
+	state, err := ReadState(file)
+	if err != nil {
+		t.Fatalf("ReadState failed: %s", err)
+	}
+	if state.Version != StateVersion {
+		t.Errorf("got version %d, want it migrated to %d", state.Version, StateVersion)
+	}
+	sort.Slice(state.Watches, func(i, j int) bool { return state.Watches[i].TxID < state.Watches[j].TxID })
+	want := []models.WatchTx{
+		{TxID: "aa", Confs: 6, Channel: "C1", ConfsCount: 2, ConfirmBlockHeight: 800000, TimeRequested: 1690000000},
+		{TxID: "bb", Confs: 1, Network: "testnet", Channel: "C2", TimeRequested: 1690000100},
+	}
+	if !reflect.DeepEqual(state.Watches, want) {
+		t.Errorf("got watches %+v, want %+v", state.Watches, want)
+	}
+
+	//the ids are filled in as the watches are added back, mainnet watches keep their empty network
+	store := NewWatchStore()
+	for _, watchTx := range state.Watches {
+		store.Add(watchTx)
+	}
+	if _, ok := store.Get("mainnet:aa:C1"); !ok {
+		t.Errorf("the mainnet watch isn't stored under mainnet:aa:C1")
+	}
+	if _, ok := store.Get("testnet:bb:C2"); !ok {
+		t.Errorf("the testnet watch isn't stored under testnet:bb:C2")
+	}
+}
+
+func TestWriteStateRoundTrip(t *testing.T) {
+	state := State{Version: StateVersion, Watches: []models.WatchTx{
+		{
+			ID: "mainnet:aa:C1", TxID: "aa", Confs: 3, Channel: "C1", Frontend: models.FrontendSlack, User: "U1",
+			ThreadID: "1690000000.000100", StatusMessageID: "1690000000.000200", ConfsCount: 1,
+			ConfirmBlockHeight: 800000, ConfirmBlockHash: "00000000bb", ConfirmBlockTime: 1690000600, ConfirmBlockPool: "Foundry USA",
+			TimeRequested: 1690000000, ExpiresAt: 1691000000, State: models.StateConfirmed, Fee: 2115, VSize: 141,
+			Inputs: "cc:0,dd:1", Notify: "finance@example.com,ops@example.com",
+		},
+		{
+			ID: "testnet:tb1qaddress:matrix/!room:example.com", Address: "tb1qaddress", WatchedFromHeight: 2500000, Confs: 1,
+			Network: "testnet", Channel: "!room:example.com", Frontend: models.FrontendMatrix, TimeRequested: 1690000100,
+		},
+	}}
+	var file bytes.Buffer
+	if err := WriteState(&file, state); err != nil {
+		t.Fatalf("WriteState failed: %s", err)
+	}
+	read, err := ReadState(&file)
+	if err != nil {
+		t.Fatalf("ReadState failed: %s", err)
+	}
+	if !reflect.DeepEqual(read, state) {
+		t.Errorf("got %+v, want %+v", read, state)
+	}
+}
+
+func TestReadStateNewerVersion(t *testing.T) {
+	var file bytes.Buffer
+	fz := gzip.NewWriter(&file)
+	encoder := gob.NewEncoder(fz)
+	if err := encoder.Encode(StateHeader{Magic: StateMagic, Version: StateVersion + 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.Encode([]models.WatchTx{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadState(&file); err == nil {
+		t.Errorf("a save file from a newer build was read")
+	}
+}
+
+// builds before watchTxV2 was split out wrote the version 2 watches straight from models.WatchTx
+func TestReadStateV2FromWatchTx(t *testing.T) {
+	watches := []models.WatchTx{{
+		ID: "mainnet:aa:C1", TxID: "aa", Confs: 3, Channel: "C1", ConfsCount: 1, ConfirmBlockHeight: 800000,
+		ConfirmBlockHash: "00000000bb", TimeRequested: 1690000000, State: models.StateConfirmed, Fee: 2115, VSize: 141,
+	}}
+	var file bytes.Buffer
+	fz := gzip.NewWriter(&file)
+	encoder := gob.NewEncoder(fz)
+	if err := encoder.Encode(StateHeader{Magic: StateMagic, Version: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.Encode(watches); err != nil {
+		t.Fatal(err)
+	}
+	if err := fz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	state, err := ReadState(&file)
+	if err != nil {
+		t.Fatalf("ReadState failed: %s", err)
+	}
+	if !reflect.DeepEqual(state.Watches, watches) {
+		t.Errorf("got %+v, want %+v", state.Watches, watches)
+	}
+}
+
+// a WatchTx field missing from the save file would be dropped on every restart
+func TestWatchTxV2HasEveryField(t *testing.T) {
+	current := reflect.TypeOf(models.WatchTx{})
+	saved := reflect.TypeOf(watchTxV2{})
+	for i := 0; i < current.NumField(); i++ {
+		field := current.Field(i)
+		savedField, ok := saved.FieldByName(field.Name)
+		if !ok || savedField.Type != field.Type {
+			t.Errorf("WatchTx.%s isn't written to the save file, add it to watchTxV2 or bump StateVersion", field.Name)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"tx-tracker/pkg/models"
+)
+
+// WatchStore holds the active watches by their stable id, along with an index from each watched transaction
+// (or unpaid address) to the watches on it, so the chain is only asked about a transaction once however many
+// channels are watching it
+type WatchStore struct {
+	mutex     sync.RWMutex
+	watches   map[string]models.WatchTx
+	byTx      map[string]map[string]bool
+	persister Persister
+	version   uint64
+	changed   chan struct{}
+	// persistMutex orders the writes to the persister, which happen after mutex is released so slow storage
+	// doesn't hold up the readers. written is the version of the last write of each watch, a write that lost the
+	// race to a later change of the same watch is skipped.
+	persistMutex sync.Mutex
+	written      map[string]uint64
+}
+
+// Persister records every change made to a WatchStore as it happens
+type Persister interface {
+	SaveWatch(watchTx models.WatchTx) error
+	// FinishWatch removes a watch that stopped, reason is one of the models.Finish* values
+	FinishWatch(watchTx models.WatchTx, reason string) error
+}
+
+func NewWatchStore() *WatchStore {
+	return &WatchStore{
+		watches: make(map[string]models.WatchTx),
+		byTx:    make(map[string]map[string]bool),
+		changed: make(chan struct{}, 1),
+		written: make(map[string]uint64),
+	}
+}
+
+// SetPersister has every later change to the store written through to persister
+func (s *WatchStore) SetPersister(persister Persister) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.persister = persister
+}
+
+// change is a change to a watch waiting to be written to the persister, reason is set when the watch finished
+type change struct {
+	watchTx   models.WatchTx
+	reason    string
+	version   uint64
+	persister Persister
+}
+
+// recordChange marks the store changed and returns the write to make once the lock is released, the caller holds
+// the lock
+func (s *WatchStore) recordChange(watchTx models.WatchTx, reason string) change {
+	s.markChanged()
+	return change{watchTx: watchTx, reason: reason, version: s.version, persister: s.persister}
+}
+
+// persist writes a change to the persister, the caller must not hold the lock
+func (s *WatchStore) persist(c change) {
+	if c.persister == nil {
+		return
+	}
+	s.persistMutex.Lock()
+	defer s.persistMutex.Unlock()
+	if s.written[c.watchTx.ID] > c.version {
+		return
+	}
+	s.written[c.watchTx.ID] = c.version
+	if len(c.reason) > 0 {
+		err := c.persister.FinishWatch(c.watchTx, c.reason)
+		if err != nil {
+			log.Printf("failed to persist finished watch %s: %s", c.watchTx.ID, err.Error())
+		}
+		return
+	}
+	err := c.persister.SaveWatch(c.watchTx)
+	if err != nil {
+		log.Printf("failed to persist watch %s: %s", c.watchTx.ID, err.Error())
+	}
+}
+
+// markChanged bumps the version and wakes anyone waiting on Changed, the caller holds the lock
+func (s *WatchStore) markChanged() {
+	s.version++
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Version counts the changes made to the store, it goes up on every add, update & remove
+func (s *WatchStore) Version() uint64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.version
+}
+
+// Changed receives after the store changes, several changes made close together may only be signalled once
+func (s *WatchStore) Changed() <-chan struct{} {
+	return s.changed
+}
+
+// WatchID returns the id of a watch, made up of what is watched, the network and the channel it was requested from.
+// Channels of frontends other than slack are prefixed with the frontend, keeping the ids of older slack watches.
+func WatchID(watchTx models.WatchTx) string {
+	subject := watchTx.TxID
+	if len(subject) == 0 {
+		subject = watchTx.Address
+	}
+	channel := watchTx.Channel
+	if frontend := NormalizeFrontend(watchTx.Frontend); frontend != models.FrontendSlack {
+		channel = fmt.Sprintf("%s/%s", frontend, channel)
+	}
+	return fmt.Sprintf("%s:%s:%s", NormalizeNetwork(watchTx.Network), subject, channel)
+}
+
+// TxKey returns the index key of what a watch is waiting on, the transaction or the address while it is unpaid
+func TxKey(watchTx models.WatchTx) string {
+	if len(watchTx.TxID) == 0 {
+		return fmt.Sprintf("%s:address:%s", NormalizeNetwork(watchTx.Network), watchTx.Address)
+	}
+	return fmt.Sprintf("%s:tx:%s", NormalizeNetwork(watchTx.Network), watchTx.TxID)
+}
+
+// NormalizeNetwork maps the empty network watches default to onto mainnet
+func NormalizeNetwork(network string) string {
+	if len(network) == 0 {
+		return "mainnet"
+	}
+	return network
+}
+
+// NormalizeFrontend maps the empty frontend of watches saved before there was more than one onto slack
+func NormalizeFrontend(frontend string) string {
+	if len(frontend) == 0 {
+		return models.FrontendSlack
+	}
+	return frontend
+}
+
+// Add stores a new watch, giving it an id when it doesn't have one, it returns false if the id is already watched
+func (s *WatchStore) Add(watchTx models.WatchTx) (models.WatchTx, bool) {
+	if len(watchTx.ID) == 0 {
+		watchTx.ID = WatchID(watchTx)
+	}
+	s.mutex.Lock()
+	if _, exists := s.watches[watchTx.ID]; exists {
+		s.mutex.Unlock()
+		return watchTx, false
+	}
+	s.watches[watchTx.ID] = watchTx
+	s.index(watchTx)
+	c := s.recordChange(watchTx, "")
+	s.mutex.Unlock()
+	s.persist(c)
+	return watchTx, true
+}
+
+func (s *WatchStore) index(watchTx models.WatchTx) {
+	key := TxKey(watchTx)
+	if _, ok := s.byTx[key]; !ok {
+		s.byTx[key] = make(map[string]bool)
+	}
+	s.byTx[key][watchTx.ID] = true
+}
+
+func (s *WatchStore) unindex(watchTx models.WatchTx) {
+	key := TxKey(watchTx)
+	delete(s.byTx[key], watchTx.ID)
+	if len(s.byTx[key]) == 0 {
+		delete(s.byTx, key)
+	}
+}
+
+// Update applies a change to the stored watch and returns the result, false when the watch is no longer stored
+func (s *WatchStore) Update(id string, update func(watchTx *models.WatchTx)) (models.WatchTx, bool) {
+	s.mutex.Lock()
+	watchTx, ok := s.watches[id]
+	if !ok {
+		s.mutex.Unlock()
+		return watchTx, false
+	}
+	s.unindex(watchTx)
+	update(&watchTx)
+	watchTx.ID = id
+	s.watches[id] = watchTx
+	s.index(watchTx)
+	c := s.recordChange(watchTx, "")
+	s.mutex.Unlock()
+	s.persist(c)
+	return watchTx, true
+}
+
+// Remove stops a watch, reason is one of the models.Finish* values
+func (s *WatchStore) Remove(id string, reason string) (models.WatchTx, bool) {
+	s.mutex.Lock()
+	watchTx, ok := s.watches[id]
+	if !ok {
+		s.mutex.Unlock()
+		return watchTx, false
+	}
+	delete(s.watches, id)
+	s.unindex(watchTx)
+	c := s.recordChange(watchTx, reason)
+	s.mutex.Unlock()
+	s.persist(c)
+	return watchTx, true
+}
+
+func (s *WatchStore) Get(id string) (models.WatchTx, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	watchTx, ok := s.watches[id]
+	return watchTx, ok
+}
+
+// All returns a copy of every watch, oldest first
+func (s *WatchStore) All() []models.WatchTx {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	watches := make([]models.WatchTx, 0, len(s.watches))
+	for _, watchTx := range s.watches {
+		watches = append(watches, watchTx)
+	}
+	sort.Slice(watches, func(i, j int) bool {
+		if watches[i].TimeRequested == watches[j].TimeRequested {
+			return watches[i].ID < watches[j].ID
+		}
+		return watches[i].TimeRequested < watches[j].TimeRequested
+	})
+	return watches
+}
+
+func (s *WatchStore) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.watches)
+}
+
+// Groups returns copies of every watch, grouped by the transaction or address they wait on
+func (s *WatchStore) Groups() [][]models.WatchTx {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	keys := make([]string, 0, len(s.byTx))
+	for key := range s.byTx {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	groups := make([][]models.WatchTx, 0, len(keys))
+	for _, key := range keys {
+		group := make([]models.WatchTx, 0, len(s.byTx[key]))
+		for id := range s.byTx[key] {
+			group = append(group, s.watches[id])
+		}
+		sort.Slice(group, func(i, j int) bool { return group[i].ID < group[j].ID })
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// Grouped returns the Groups on a single network
+func (s *WatchStore) Grouped(network string) [][]models.WatchTx {
+	network = NormalizeNetwork(network)
+	groups := [][]models.WatchTx{}
+	for _, group := range s.Groups() {
+		if NormalizeNetwork(group[0].Network) == network {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	"tx-tracker/pkg/models"
+)
+
+// groupIDs returns the ids of the watches in each group
+func groupIDs(groups [][]models.WatchTx) [][]string {
+	ids := [][]string{}
+	for _, group := range groups {
+		groupIds := []string{}
+		for _, watchTx := range group {
+			groupIds = append(groupIds, watchTx.ID)
+		}
+		ids = append(ids, groupIds)
+	}
+	return ids
+}
+
+func TestWatchStoreAdd(t *testing.T) {
+	store := NewWatchStore()
+	watchTx, added := store.Add(models.WatchTx{TxID: "aa", Channel: "C1", Confs: 6})
+	if !added || watchTx.ID != "mainnet:aa:C1" {
+		t.Fatalf("got %q added %v, want mainnet:aa:C1 added", watchTx.ID, added)
+	}
+	//mainnet is the default network, the same watch on it isn't added twice
+	if _, added := store.Add(models.WatchTx{TxID: "aa", Channel: "C1", Network: "mainnet", Confs: 3}); added {
+		t.Errorf("the same transaction was watched twice from the channel")
+	}
+	if stored, _ := store.Get("mainnet:aa:C1"); stored.Confs != 6 {
+		t.Errorf("got a target of %d, want the first watch's 6 kept", stored.Confs)
+	}
+	if _, added := store.Add(models.WatchTx{TxID: "aa", Channel: "!room", Frontend: models.FrontendMatrix}); !added {
+		t.Errorf("a watch from another frontend's channel wasn't added")
+	}
+}
+
+func TestWatchStoreGrouped(t *testing.T) {
+	store := NewWatchStore()
+	store.Add(models.WatchTx{TxID: "aa", Channel: "C1"})
+	store.Add(models.WatchTx{TxID: "aa", Channel: "C2", Network: "mainnet"})
+	store.Add(models.WatchTx{TxID: "aa", Channel: "C1", Network: "testnet"})
+	store.Add(models.WatchTx{TxID: "bb", Channel: "C1"})
+	store.Add(models.WatchTx{Address: "bc1qaddress", Channel: "C1"})
+
+	want := [][]string{{"mainnet:bc1qaddress:C1"}, {"mainnet:aa:C1", "mainnet:aa:C2"}, {"mainnet:bb:C1"}}
+	if got := groupIDs(store.Grouped("")); !reflect.DeepEqual(got, want) {
+		t.Errorf("Grouped(\"\") = %v, want %v", got, want)
+	}
+	if got := groupIDs(store.Grouped("testnet")); !reflect.DeepEqual(got, [][]string{{"testnet:aa:C1"}}) {
+		t.Errorf("Grouped(\"testnet\") = %v", got)
+	}
+}
+
+// the index follows a watch as it changes, an address watch moves on to the transaction paying it
+func TestWatchStoreIndexFollowsUpdates(t *testing.T) {
+	store := NewWatchStore()
+	paid, _ := store.Add(models.WatchTx{Address: "bc1qaddress", Channel: "C1"})
+	store.Add(models.WatchTx{TxID: "aa", Channel: "C2"})
+
+	updated, ok := store.Update(paid.ID, func(watchTx *models.WatchTx) {
+		watchTx.TxID = "aa"
+	})
+	if !ok || updated.ID != paid.ID {
+		t.Fatalf("got %q updated %v, want the id kept", updated.ID, ok)
+	}
+	want := [][]string{{"mainnet:aa:C2", "mainnet:bc1qaddress:C1"}}
+	if got := groupIDs(store.Groups()); !reflect.DeepEqual(got, want) {
+		t.Errorf("Groups() = %v, want %v", got, want)
+	}
+
+	if _, ok := store.Remove("mainnet:aa:C2", models.FinishCancelled); !ok {
+		t.Fatalf("the watch wasn't removed")
+	}
+	want = [][]string{{"mainnet:bc1qaddress:C1"}}
+	if got := groupIDs(store.Groups()); !reflect.DeepEqual(got, want) {
+		t.Errorf("Groups() = %v, want %v", got, want)
+	}
+	store.Remove(paid.ID, models.FinishCompleted)
+	if groups := store.Groups(); len(groups) != 0 {
+		t.Errorf("got %d groups left, want the emptied ones dropped from the index", len(groups))
+	}
+	if _, ok := store.Update(paid.ID, func(watchTx *models.WatchTx) {}); ok {
+		t.Errorf("a removed watch was updated")
+	}
+}
+
+// recordingPersister keeps what it was told, in order
+type recordingPersister struct {
+	saved    []string
+	finished []string
+}
+
+func (p *recordingPersister) SaveWatch(watchTx models.WatchTx) error {
+	p.saved = append(p.saved, watchTx.ID)
+	return nil
+}
+
+func (p *recordingPersister) FinishWatch(watchTx models.WatchTx, reason string) error {
+	p.finished = append(p.finished, watchTx.ID+" "+reason)
+	return nil
+}
+
+func TestWatchStorePersister(t *testing.T) {
+	store := NewWatchStore()
+	persister := &recordingPersister{}
+	store.SetPersister(persister)
+	watchTx, _ := store.Add(models.WatchTx{TxID: "aa", Channel: "C1"})
+	store.Update(watchTx.ID, func(stored *models.WatchTx) {
+		stored.ConfsCount = 1
+	})
+	store.Remove(watchTx.ID, models.FinishCompleted)
+
+	if !reflect.DeepEqual(persister.saved, []string{watchTx.ID, watchTx.ID}) {
+		t.Errorf("got saves %v, want the add and the update", persister.saved)
+	}
+	if !reflect.DeepEqual(persister.finished, []string{watchTx.ID + " " + models.FinishCompleted}) {
+		t.Errorf("got finishes %v", persister.finished)
+	}
+	if store.Version() != 3 {
+		t.Errorf("got version %d, want 3 after three changes", store.Version())
+	}
+}
@@ -1,11 +1,10 @@
 package utils
 
 import (
-	"compress/gzip"
-	"encoding/gob"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp"
 	"time"
 	"tx-tracker/pkg/models"
@@ -17,7 +16,12 @@ func ConvertTimestamp(unixTime int) string {
 	return timeStamp.String()
 }
 
-func Load[T comparable](filename string, toLoad *Set[T]) error {
+// FormatSats formats an amount in sats as BTC
+func FormatSats(sats int64) string {
+	return fmt.Sprintf("%d.%08d BTC", sats/100_000_000, sats%100_000_000)
+}
+
+func Load(filename string, toLoad *WatchStore) error {
 
 	fi, err := os.Open(filename)
 	if err != nil {
@@ -45,49 +49,198 @@ func Load[T comparable](filename string, toLoad *Set[T]) error {
 	if err != nil {
 		log.Fatal(err)
 	}
-	fz, err := gzip.NewReader(fi)
-	if err != nil {
-		return err
-	}
-	defer fz.Close()
-
-	decoder := gob.NewDecoder(fz)
-	rawDic := make(map[T]string, 0)
-	err = decoder.Decode(&rawDic)
+	state, err := ReadState(fi)
 	if err != nil {
 		return err
 	}
-	for key, value := range rawDic {
-		toLoad.Add(key, value)
+	for _, watchTx := range state.Watches {
+		toLoad.Add(watchTx)
 	}
 
 	return nil
 }
 
-func Save[T comparable](filename string, toSave *Set[T]) error {
-	fi, err := os.Create(filename)
+// Save writes the store to a temp file next to filename, syncs it and renames it over filename, so a crash part
+// way through leaves the last snapshot in place
+func Save(filename string, toSave *WatchStore) error {
+	fi, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
 	if err != nil {
 		return err
 	}
+	tmpName := fi.Name()
+	defer os.Remove(tmpName)
 	defer fi.Close()
 
-	fz := gzip.NewWriter(fi)
-	defer fz.Close()
+	err = WriteState(fi, StateOf(toSave))
+	if err != nil {
+		return err
+	}
+	err = fi.Sync()
+	if err != nil {
+		return err
+	}
+	err = fi.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpName, filename)
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(filename))
+}
 
-	encoder := gob.NewEncoder(fz)
-	err = encoder.Encode(&toSave.dic)
+// syncDir flushes a rename in dir to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
 		return err
 	}
+	defer d.Close()
+	return d.Sync()
+}
 
-	return nil
+// WatchExtension is how much longer a watch is kept each time it is extended
+const WatchExtension = 7 * 24 * time.Hour
+
+// WatchExpiry returns when a watch is dropped, two weeks after the request unless it has been extended
+func WatchExpiry(watchTx models.WatchTx) time.Time {
+	if watchTx.ExpiresAt > 0 {
+		return time.Unix(watchTx.ExpiresAt, 0).UTC()
+	}
+	return time.Unix(watchTx.TimeRequested, 0).UTC().AddDate(0, 0, 14)
 }
 
-func RemoveOldItems(toCheck *Set[models.WatchTx], unixTimeNow int64) {
-	for _, key := range toCheck.Keys() {
-		twoWeeks := time.Unix(key.TimeRequested, 0).UTC().AddDate(0, 0, 14).Unix()
-		if twoWeeks < unixTimeNow {
-			toCheck.Remove(key)
+// ExtendWatch keeps the watch with the id for another WatchExtension
+func ExtendWatch(store *WatchStore, id string) (models.WatchTx, bool) {
+	return store.Update(id, func(watchTx *models.WatchTx) {
+		watchTx.ExpiresAt = WatchExpiry(*watchTx).Add(WatchExtension).Unix()
+	})
+}
+
+func RemoveOldItems(toCheck *WatchStore, unixTimeNow int64) {
+	for _, watchTx := range toCheck.All() {
+		if WatchExpiry(watchTx).Unix() < unixTimeNow {
+			toCheck.Remove(watchTx.ID, models.FinishExpired)
 		}
 	}
 }
+
+// InChannel is true for watches requested from the frontend's channel
+func InChannel(watchTx models.WatchTx, frontend string, channel string) bool {
+	return watchTx.Channel == channel && NormalizeFrontend(watchTx.Frontend) == NormalizeFrontend(frontend)
+}
+
+// WatchesForChannel returns the watches requested from a channel of the frontend, oldest first
+func WatchesForChannel(store *WatchStore, frontend string, channel string) []models.WatchTx {
+	watches := []models.WatchTx{}
+	for _, watchTx := range store.All() {
+		if InChannel(watchTx, frontend, channel) {
+			watches = append(watches, watchTx)
+		}
+	}
+	return watches
+}
+
+// WatchesForUser returns the watches the user asked for on the frontend, across every channel
+func WatchesForUser(store *WatchStore, frontend string, user string) []models.WatchTx {
+	watches := []models.WatchTx{}
+	for _, watchTx := range store.All() {
+		if NormalizeFrontend(watchTx.Frontend) == NormalizeFrontend(frontend) && len(user) > 0 && watchTx.User == user {
+			watches = append(watches, watchTx)
+		}
+	}
+	return watches
+}
+
+// matchesWatch is true for the channel's watches on the txid or address
+func matchesWatch(watchTx models.WatchTx, frontend string, channel string, id string) bool {
+	return InChannel(watchTx, frontend, channel) && len(id) > 0 && (watchTx.TxID == id || watchTx.Address == id)
+}
+
+// RemoveWatches stops every watch in the channel on the txid or address, returning the watches removed
+func RemoveWatches(store *WatchStore, frontend string, channel string, id string) []models.WatchTx {
+	removed := []models.WatchTx{}
+	for _, watchTx := range store.All() {
+		if !matchesWatch(watchTx, frontend, channel, id) {
+			continue
+		}
+		if removedTx, ok := store.Remove(watchTx.ID, models.FinishCancelled); ok {
+			removed = append(removed, removedTx)
+		}
+	}
+	return removed
+}
+
+// UpdateWatchConfs changes the confirmation target of the channel's watches on the txid or address, keeping
+// their progress, and returns the updated watches. Watches that already meet the new target are handed back on
+// watchTransaction to be finished.
+func UpdateWatchConfs(store *WatchStore, frontend string, channel string, id string, confs int, watchTransaction chan models.WatchTx) []models.WatchTx {
+	updated := []models.WatchTx{}
+	for _, watchTx := range store.All() {
+		if !matchesWatch(watchTx, frontend, channel, id) {
+			continue
+		}
+		updatedTx, ok := store.Update(watchTx.ID, func(watchTx *models.WatchTx) {
+			watchTx.Confs = confs
+		})
+		if !ok {
+			continue
+		}
+		updated = append(updated, updatedTx)
+		if updatedTx.ConfsCount >= updatedTx.Confs {
+			watchTransaction <- updatedTx
+		}
+	}
+	return updated
+}
+
+// MoveWatches replaces the channel's watches on oldTxId with ones on newTxId sent to watchTransaction, keeping the
+// confirmation target, it returns false when nothing was watching oldTxId
+func MoveWatches(store *WatchStore, frontend string, channel string, oldTxId string, newTxId string, watchTransaction chan models.WatchTx) bool {
+	moved := false
+	for _, watchTx := range store.All() {
+		if !InChannel(watchTx, frontend, channel) || watchTx.TxID != oldTxId {
+			continue
+		}
+		if _, ok := store.Remove(watchTx.ID, models.FinishMoved); !ok {
+			continue
+		}
+		watchTransaction <- models.WatchTx{
+			TxID:            newTxId,
+			Address:         watchTx.Address,
+			Confs:           watchTx.Confs,
+			Network:         watchTx.Network,
+			Channel:         watchTx.Channel,
+			Frontend:        watchTx.Frontend,
+			User:            watchTx.User,
+			Notify:          watchTx.Notify,
+			ThreadID:        watchTx.ThreadID,
+			StatusMessageID: watchTx.StatusMessageID,
+			TimeRequested:   time.Now().UTC().Unix(),
+		}
+		moved = true
+	}
+	return moved
+}
+
+// DescribeWatch summarizes a watch on one line for the list commands
+func DescribeWatch(watchTx models.WatchTx, now time.Time) string {
+	network := watchTx.Network
+	if len(network) == 0 {
+		network = "mainnet"
+	}
+	subject := watchTx.TxID
+	if len(watchTx.Address) > 0 && len(watchTx.TxID) == 0 {
+		subject = fmt.Sprintf("address %s (waiting for a payment)", watchTx.Address)
+	} else if len(watchTx.Address) > 0 {
+		subject = fmt.Sprintf("%s paying %s", watchTx.TxID, watchTx.Address)
+	}
+	state := watchTx.State
+	if len(state) == 0 {
+		state = "not seen yet"
+	}
+	age := now.Sub(time.Unix(watchTx.TimeRequested, 0)).Truncate(time.Minute)
+	expiry := WatchExpiry(watchTx)
+	return fmt.Sprintf("%s on %s: %d/%d confirmations, %s, added %s ago, expires %s", subject, network, watchTx.ConfsCount, watchTx.Confs, state, age, expiry.Format("2006-01-02 15:04 MST"))
+}
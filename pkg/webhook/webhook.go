@@ -0,0 +1,237 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"tx-tracker/pkg/models"
+	"tx-tracker/pkg/utils"
+)
+
+// Event types sent in the payload's event field and the X-Tx-Tracker-Event header
+const (
+	EventFirstConfirmation = "first_confirmation"
+	EventConfirmation      = "confirmation"
+	EventFinal             = "final"
+	EventReorg             = "reorg"
+)
+
+// Event is the JSON body posted to every webhook URL
+type Event struct {
+	// ID is unique to the event and stays the same across retries, receivers can use it to drop duplicates
+	ID                  string `json:"id"`
+	Event               string `json:"event"`
+	WatchID             string `json:"watch_id"`
+	TxID                string `json:"txid"`
+	Address             string `json:"address,omitempty"`
+	Amount              int64  `json:"amount,omitempty"`
+	Network             string `json:"network"`
+	Confirmations       int    `json:"confirmations"`
+	TargetConfirmations int    `json:"target_confirmations"`
+	// LostConfirmations is set on reorg events
+	LostConfirmations int    `json:"lost_confirmations,omitempty"`
+	BlockHash         string `json:"block_hash,omitempty"`
+	BlockHeight       int    `json:"block_height,omitempty"`
+	BlockTime         int64  `json:"block_time,omitempty"`
+	SentAt            int64  `json:"sent_at"`
+}
+
+// Config sets where the events are posted and how they are signed
+type Config struct {
+	URLs []string
+	// Secret signs each body with HMAC-SHA256, the X-Tx-Tracker-Signature header is "sha256=" followed by the hex
+	// digest of the X-Tx-Tracker-Timestamp header, a "." and the body
+	Secret string
+	// Attempts is how many times an event is posted before it goes to the dead letter file
+	Attempts int
+	// DeadLetterFile gets a JSON line for every event that couldn't be delivered
+	DeadLetterFile string
+}
+
+// Webhook posts the confirmation events of every watch to the configured URLs
+type Webhook struct {
+	config     Config
+	client     *http.Client
+	deadLetter sync.Mutex
+	// backoff is how long to wait before the first retry, it doubles after each one
+	backoff time.Duration
+}
+
+func NewWebhook(config Config) *Webhook {
+	if config.Attempts <= 0 {
+		config.Attempts = 5
+	}
+	return &Webhook{
+		config:  config,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		backoff: time.Second,
+	}
+}
+
+func newEvent(eventType string, watchTx models.WatchTx) Event {
+	now := time.Now().UTC()
+	return Event{
+		ID:                  fmt.Sprintf("%s:%s:%d:%d", watchTx.ID, eventType, watchTx.ConfsCount, now.UnixNano()),
+		Event:               eventType,
+		WatchID:             watchTx.ID,
+		TxID:                watchTx.TxID,
+		Address:             watchTx.Address,
+		Amount:              watchTx.Amount,
+		Network:             utils.NormalizeNetwork(watchTx.Network),
+		Confirmations:       watchTx.ConfsCount,
+		TargetConfirmations: watchTx.Confs,
+		BlockHash:           watchTx.ConfirmBlockHash,
+		BlockHeight:         watchTx.ConfirmBlockHeight,
+		BlockTime:           watchTx.ConfirmBlockTime,
+		SentAt:              now.Unix(),
+	}
+}
+
+// SendErrorMessage is a reply to the requester, there is no event for it
+func (w *Webhook) SendErrorMessage(watchTx models.WatchTx, text string) {}
+
+func (w *Webhook) SendMempoolMessage(watchTx models.WatchTx) {}
+
+func (w *Webhook) SendDroppedMessage(watchTx models.WatchTx) {}
+
+func (w *Webhook) SendReplacedMessage(watchTx models.WatchTx) {}
+
+func (w *Webhook) SendReorgMessage(watchTx models.WatchTx, lostConfs int) {
+	event := newEvent(EventReorg, watchTx)
+	event.LostConfirmations = lostConfs
+	w.Send(event)
+}
+
+func (w *Webhook) SendFirstConfMessage(watchTx models.WatchTx, confirmed models.ConfirmedPayload) {
+	event := newEvent(EventFirstConfirmation, watchTx)
+	if confirmed.BlockTime != nil {
+		event.BlockTime = int64(*confirmed.BlockTime)
+	}
+	w.Send(event)
+}
+
+func (w *Webhook) SendUpdatedConfMessage(watchTx models.WatchTx) {
+	w.Send(newEvent(EventConfirmation, watchTx))
+}
+
+func (w *Webhook) SendFinalMessage(watchTx models.WatchTx) {
+	w.Send(newEvent(EventFinal, watchTx))
+}
+
+// Send posts the event to every URL in the background
+func (w *Webhook) Send(event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("failed to encode webhook event %s: %s", event.ID, err.Error())
+		return
+	}
+	for _, url := range w.config.URLs {
+		go w.deliver(url, event, body)
+	}
+}
+
+// deliver posts body to url, retrying with backoff on network errors, 429s and 5xxs. An event that still
+// isn't delivered is written to the dead letter file.
+func (w *Webhook) deliver(url string, event Event, body []byte) {
+	backoff := w.backoff
+	attempts := 0
+	for {
+		attempts++
+		retry, err := w.post(url, event, body)
+		if err == nil {
+			return
+		}
+		log.Printf("\nwebhook %s attempt %d for event %s failed: %s", url, attempts, event.ID, err.Error())
+		if !retry || attempts >= w.config.Attempts {
+			w.writeDeadLetter(url, event, attempts, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends one attempt, returning whether a failure is worth retrying
+func (w *Webhook) post(url string, event Event, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().UTC().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Tx-Tracker-Event", event.Event)
+	req.Header.Set("X-Tx-Tracker-Delivery", event.ID)
+	req.Header.Set("X-Tx-Tracker-Timestamp", timestamp)
+	if len(w.config.Secret) > 0 {
+		req.Header.Set("X-Tx-Tracker-Signature", "sha256="+Sign(w.config.Secret, timestamp, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("unexpected status %s", resp.Status)
+}
+
+// Sign returns the hex HMAC-SHA256 of the timestamp and body, the value receivers check the signature header against
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deadLetter is a line of the dead letter file
+type deadLetter struct {
+	URL      string `json:"url"`
+	Event    Event  `json:"event"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error"`
+	FailedAt int64  `json:"failed_at"`
+}
+
+func (w *Webhook) writeDeadLetter(url string, event Event, attempts int, errDeliver error) {
+	log.Printf("\ngiving up on webhook event %s to %s after %d attempts", event.ID, url, attempts)
+	if len(w.config.DeadLetterFile) == 0 {
+		return
+	}
+	line, err := json.Marshal(deadLetter{
+		URL:      url,
+		Event:    event,
+		Attempts: attempts,
+		Error:    errDeliver.Error(),
+		FailedAt: time.Now().UTC().Unix(),
+	})
+	if err != nil {
+		log.Printf("failed to encode dead letter for event %s: %s", event.ID, err.Error())
+		return
+	}
+	w.deadLetter.Lock()
+	defer w.deadLetter.Unlock()
+	fi, err := os.OpenFile(w.config.DeadLetterFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("failed to open dead letter file %s: %s", w.config.DeadLetterFile, err.Error())
+		return
+	}
+	defer fi.Close()
+	_, err = fi.Write(append(line, '\n'))
+	if err != nil {
+		log.Printf("failed to write dead letter for event %s: %s", event.ID, err.Error())
+	}
+}
@@ -0,0 +1,51 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// the digests were worked out separately with python's hmac module
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":"evt_1","event":"final"}`)
+	tests := []struct {
+		secret    string
+		timestamp string
+		body      []byte
+		digest    string
+	}{
+		{"whsec_test", "1690000000", body, "af042e6ebf3322f910ef2fb9ed7d3c4c3102fee4bd36c923f33517951955660e"},
+		{"other", "1690000000", body, "0f14a6dd4c7e059930c94d9ef9701f1f3cd1f8fb0fa42db524793f40973cfbe7"},
+		{"whsec_test", "1690000000", nil, "18230a2d2030c8356f80d713b6e2ebb3e660b4f8c306d488e01880247f048306"},
+	}
+	for _, test := range tests {
+		if digest := Sign(test.secret, test.timestamp, test.body); digest != test.digest {
+			t.Errorf("Sign(%q, %q, %q) = %s, want %s", test.secret, test.timestamp, test.body, digest, test.digest)
+		}
+	}
+}
+
+func TestPostSignsTheBody(t *testing.T) {
+	event := Event{ID: "evt_1", Event: EventFinal}
+	body := []byte(`{"id":"evt_1","event":"final"}`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ := io.ReadAll(r.Body)
+		want := "sha256=" + Sign("whsec_test", r.Header.Get("X-Tx-Tracker-Timestamp"), received)
+		if signature := r.Header.Get("X-Tx-Tracker-Signature"); signature != want {
+			t.Errorf("got signature %s, want %s", signature, want)
+		}
+		if r.Header.Get("X-Tx-Tracker-Event") != EventFinal || r.Header.Get("X-Tx-Tracker-Delivery") != "evt_1" {
+			t.Errorf("got event headers %s & %s", r.Header.Get("X-Tx-Tracker-Event"), r.Header.Get("X-Tx-Tracker-Delivery"))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	webhook := NewWebhook(Config{URLs: []string{server.URL}, Secret: "whsec_test"})
+	retry, err := webhook.post(server.URL, event, body)
+	if err != nil || retry {
+		t.Errorf("got retry %v & error %v, want it delivered", retry, err)
+	}
+}